@@ -5,106 +5,117 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/internal"
 	"github.com/gomxapp/gomx/internal/util"
-	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
-	"strings"
+	"strconv"
 )
 
-type ApiRegisterFunc = func(tree *Router) (string, string, http.Handler)
-
-var registerFuncs []ApiRegisterFunc
+const htmlContentType = "text/html; charset=utf-8"
 
-func (router *Router) initApi() {
-	for _, registerFunc := range registerFuncs {
-		path, method, handler := registerFunc(router)
-		_, err := router.routeTree.Tree.AddRelativeChild(path, method, handler, nil)
-		if err != nil {
-			log.Fatalln(err)
-		}
-	}
-}
+type ApiRegisterFunc = func(tree *Router) (string, string, http.Handler)
 
 // Register accepts an ApiRegisterFunc that returns the new API path, method, and handler.
 // The function is called during router Init with that router instance.
+//
+// Register and the other package-level Register functions add to
+// DefaultRegistry.
 func Register(registerFunc ApiRegisterFunc) {
-	registerFuncs = append(registerFuncs, registerFunc)
+	DefaultRegistry.Register(registerFunc)
 }
 
 // RegisterOnPath calls Register with an ApiRegisterFunc that simply returns
-// the given path, method, and handler.
-func RegisterOnPath(path string, method string, handler http.Handler) {
-	Register(func(tree *Router) (string, string, http.Handler) {
-		return path, method, handler
-	})
+// the given path, method, and handler. The handler is wrapped with the given
+// middleware, which only runs for this route.
+func RegisterOnPath(path string, method string, handler http.Handler, middleware ...Middleware) {
+	DefaultRegistry.RegisterOnPath(path, method, handler, middleware...)
 }
 
-// RegisterOnFile adds a new API with path = caller filename. Underscore ('_')
-// characters are replaced with a slash '/' in the path.
+// RegisterOnFile adds a new API with a path derived from the caller's file,
+// relative to config.ApiRootDir. Directories are kept, with a directory named
+// like _id_ standing for the wildcard {id} (braces are not allowed in Go import
+// paths). Underscore ('_') characters in the file name are replaced with a
+// slash '/', and a method can be given as a suffix of the file name instead of
+// as an argument.
 //
 // Example paths:
 //
 // items.go => "/items"
-// users_{id}_comments.go => "users/{id}/comments/"
-func RegisterOnFile(method string, handler http.Handler) {
-	_, file, _, ok := runtime.Caller(1)
-	if !ok {
-		log.Fatalln("Failed to register an API")
-	}
-	root, err := filepath.Abs(config.RoutesDir)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	relPath, err := filepath.Rel(root, file)
-	if err != nil {
-		log.Printf("Error when creating API for file %s\n", file)
-		log.Fatalln(err)
-	}
-	fileName := filepath.ToSlash(filepath.Base(relPath))
-	if !strings.HasSuffix(fileName, ".go") {
-		log.Fatalln("Tried to register an API route from outside a Go file... somehow")
-	}
-	path := "/" + strings.TrimSuffix(fileName, ".go")
-	converted := strings.ReplaceAll(path, "_", "/")
+// items.post.go => "/items" (method POST)
+// users/_id_/comments.go => "/users/{id}/comments"
+// users_{id}_comments.go => "/users/{id}/comments"
+func RegisterOnFile(method string, handler http.Handler, middleware ...Middleware) {
+	DefaultRegistry.registerOnFile(method, handler, middleware...)
+}
 
-	RegisterOnPath(converted, method, handler)
+// RegisterOnFileMethods is like RegisterOnFile, but registers a handler for
+// each method in handlers, so that one file can serve several methods:
+//
+//	func init() {
+//		gomx.RegisterOnFileMethods(map[string]http.Handler{
+//			http.MethodGet:  listItems,
+//			http.MethodPost: createItem,
+//		})
+//	}
+func RegisterOnFileMethods(handlers map[string]http.Handler, middleware ...Middleware) {
+	DefaultRegistry.registerOnFileMethods(handlers, middleware...)
 }
 
+// ReturnGoHTML parses htmlString as a template and executes it with data. The
+// shared partials from the reserved directory are available to the template.
+// The most recently used templates are cached by their text, so htmlString
+// should be a constant: pass request data in data, not in the text.
+//
+// The template is rendered into a buffer first, so if ReturnGoHTML returns an
+// error nothing has been written and the handler can still send an error.
 func ReturnGoHTML(w http.ResponseWriter, htmlString string, data any) error {
-	t, err := template.New("tmp").Parse(htmlString)
+	t, err := internal.APITemplates.String(htmlString)
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "text/html")
-	err = t.Execute(w, data)
-	if err != nil {
-		return err
-	}
-	return nil
+	return internal.RenderTemplate(w, 0, htmlContentType, t, data)
 }
 
+// ReturnGoHTMLFromFiles parses files (relative to config.ApiRootDir) along with
+// the shared partials and executes the template called name, or the first file
+// if name is empty. Parsed templates are cached by file set and name, and are
+// parsed again in dev mode when one of the files changes.
+//
+// Like ReturnGoHTML, nothing is written if an error is returned.
 func ReturnGoHTMLFromFiles(w http.ResponseWriter, files []string, name string, data any) error {
-	mappedFiles := util.SliceMap(files, func(file string) string {
-		return filepath.Join(config.ApiRootDir, file)
-	})
-	t, err := template.ParseFiles(mappedFiles...)
+	t, err := internal.APITemplates.Files(apiFilePaths(files), name)
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "text/html")
-	if len(name) > 0 {
-		err = t.ExecuteTemplate(w, name, data)
-	} else {
-		err = t.Execute(w, data)
-	}
+	return internal.RenderTemplate(w, 0, htmlContentType, t, data)
+}
+
+// StreamGoHTMLFromFiles is like ReturnGoHTMLFromFiles, but writes the output
+// as it is rendered and flushes it to the client in chunks. Use it for large
+// pages where time to first byte matters more than clean error handling: once
+// rendering has started, an error can no longer change the response status.
+func StreamGoHTMLFromFiles(w http.ResponseWriter, files []string, name string, data any) error {
+	t, err := internal.APITemplates.Files(apiFilePaths(files), name)
 	if err != nil {
 		return err
 	}
-	return nil
+	return internal.StreamTemplate(w, 0, htmlContentType, t, data)
+}
+
+// PreloadGoHTMLFiles marks a set of files used with ReturnGoHTMLFromFiles to be
+// parsed when the router is initialized. Outside of dev mode, this moves
+// template parsing (and parse errors) to startup.
+func PreloadGoHTMLFiles(files []string) {
+	internal.APITemplates.AddPreload(apiFilePaths(files))
+}
+
+func apiFilePaths(files []string) []string {
+	return util.SliceMap(files, func(file string) string {
+		return filepath.Join(config.ApiRootDir, file)
+	})
 }
 
 func ReturnJSON(w http.ResponseWriter, jsonString string) error {
@@ -112,6 +123,7 @@ func ReturnJSON(w http.ResponseWriter, jsonString string) error {
 		return errors.New("invalid JSON")
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsonString)))
 	_, err := w.Write([]byte(jsonString))
 	if err != nil {
 		return err
@@ -124,20 +136,26 @@ func ReturnJSONFromFile(w http.ResponseWriter, file string) error {
 	if err != nil {
 		return err
 	}
-	if !json.Valid([]byte(data)) {
+	if !json.Valid(data) {
 		return errors.New("invalid JSON")
 	}
 	w.Header().Set("Content-Type", "application/json")
-	_, err = fmt.Fprint(w, data)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err = w.Write(data)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// ReturnBadRequestSimple responds with a 400 application/problem+json body
+// with v as its detail.
+//
+// Deprecated: Use ReturnError with BadRequest, which also answers htmx
+// requests with HTML.
 func ReturnBadRequestSimple(w http.ResponseWriter, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
-	_, _ = fmt.Fprintf(w, "400 Error: %v", v)
-	log.Printf("400 Error: %v\n", v)
+	detail := fmt.Sprint(v)
+	log.Printf("400 Error: %s\n", detail)
+	problem := ProblemFor(BadRequest(detail))
+	writeProblemJSON(w, problem)
 }
@@ -0,0 +1,364 @@
+package gomx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gomxapp/gomx/htmx"
+	"github.com/gomxapp/gomx/internal"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// userSessionKey is the session key SessionAuthenticator keeps the user under.
+const userSessionKey = "gomx.user"
+
+// User is an authenticated user.
+type User struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// HasRole returns whether user has any of roles. A nil user has none.
+func (user *User) HasRole(roles ...string) bool {
+	if user == nil {
+		return false
+	}
+	for _, role := range roles {
+		if slices.Contains(user.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator finds the user making a request. It returns a nil user, and
+// no error, if the request carries no credentials it knows of or if they are
+// wrong. Errors are for failures like an unreachable user database, and are
+// answered with a 500.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*User, error)
+
+func (fn AuthenticatorFunc) Authenticate(r *http.Request) (*User, error) {
+	return fn(r)
+}
+
+// Challenger is an Authenticator that asks clients for credentials with a
+// WWW-Authenticate header on 401 responses to API clients.
+type Challenger interface {
+	Challenge() string
+}
+
+// SessionAuthenticator authenticates the user stored in the session with Login.
+// It requires the Sessions middleware.
+func SessionAuthenticator() Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*User, error) {
+		user, ok := SessionValue[*User](SessionOf(r), userSessionKey)
+		if !ok {
+			return nil, nil
+		}
+		return user, nil
+	})
+}
+
+// Login stores user in the session, for SessionAuthenticator, and renews the
+// session ID.
+func Login(r *http.Request, user *User) error {
+	session := SessionOf(r)
+	if session == nil {
+		return fmt.Errorf("login requires the Sessions middleware")
+	}
+	session.Renew()
+	if state := authStateOf(r); state != nil {
+		state.set(user)
+	}
+	return session.Set(userSessionKey, user)
+}
+
+// Logout destroys the session of r.
+func Logout(r *http.Request) {
+	if session := SessionOf(r); session != nil {
+		session.Destroy()
+	}
+	if state := authStateOf(r); state != nil {
+		state.set(nil)
+	}
+}
+
+type basicAuthenticator struct {
+	realm string
+	check func(ctx context.Context, username string, password string) (*User, error)
+}
+
+// BasicAuthenticator authenticates requests with HTTP Basic credentials, which
+// check verifies. It returns a nil user for wrong credentials.
+func BasicAuthenticator(realm string, check func(ctx context.Context, username string, password string) (*User, error)) Authenticator {
+	return &basicAuthenticator{realm: realm, check: check}
+}
+
+func (ba *basicAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	return ba.check(r.Context(), username, password)
+}
+
+func (ba *basicAuthenticator) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", ba.realm)
+}
+
+// BasicUsers returns a check for BasicAuthenticator that accepts the given
+// username and password pairs, e.g. for a staging site. The user's ID and name
+// are the username.
+func BasicUsers(passwords map[string]string, roles ...string) func(context.Context, string, string) (*User, error) {
+	return func(ctx context.Context, username string, password string) (*User, error) {
+		want, found := passwords[username]
+		// compare anyway, so unknown usernames take as long as known ones, and
+		// compare digests, whose length does not depend on the password
+		got, wanted := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(want))
+		equal := subtle.ConstantTimeCompare(got[:], wanted[:]) == 1
+		if !found || !equal {
+			return nil, nil
+		}
+		return &User{ID: username, Name: username, Roles: roles}, nil
+	}
+}
+
+type bearerAuthenticator struct {
+	check func(ctx context.Context, token string) (*User, error)
+}
+
+// BearerAuthenticator authenticates requests with an "Authorization: Bearer"
+// token, which check verifies. It returns a nil user for unknown tokens.
+func BearerAuthenticator(check func(ctx context.Context, token string) (*User, error)) Authenticator {
+	return &bearerAuthenticator{check: check}
+}
+
+func (ba *bearerAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, nil
+	}
+	return ba.check(r.Context(), token)
+}
+
+func (ba *bearerAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+// AuthOptions configure Auth.
+type AuthOptions struct {
+	// Authenticators are tried in order until one finds a user.
+	Authenticators []Authenticator
+	// LoginURL is where browsers are sent when a page requires a user. The
+	// path they came from is added as the "next" query parameter. If empty,
+	// they get a 401 instead.
+	LoginURL string
+}
+
+// authState is the user of a request, found once it is needed.
+type authState struct {
+	options *AuthOptions
+	once    sync.Once
+	user    *User
+	err     error
+}
+
+// Auth is middleware that finds the user making a request with the
+// authenticators in options. It does not turn anyone away by itself: that is
+// up to guard files, guards in front matter, RequireAuth, and RequireRoles.
+// Add it to the router with Use, after Sessions if SessionAuthenticator is
+// used:
+//
+//	router.Use(gomx.Sessions(gomx.SessionOptions{}), gomx.Auth(gomx.AuthOptions{
+//		Authenticators: []gomx.Authenticator{gomx.SessionAuthenticator()},
+//		LoginURL:       "/login",
+//	}))
+func Auth(options AuthOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authStateOf(r) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			state := &authState{options: &options}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey, state)))
+		})
+	}
+}
+
+func authStateOf(r *http.Request) *authState {
+	state, _ := r.Context().Value(authContextKey).(*authState)
+	return state
+}
+
+// authenticate returns the user of r, running the authenticators the first
+// time it is called.
+func (state *authState) authenticate(r *http.Request) (*User, error) {
+	state.once.Do(func() {
+		for _, authenticator := range state.options.Authenticators {
+			state.user, state.err = authenticator.Authenticate(r)
+			if state.user != nil || state.err != nil {
+				return
+			}
+		}
+	})
+	return state.user, state.err
+}
+
+// set replaces the user of the request, e.g. after Login.
+func (state *authState) set(user *User) {
+	state.once.Do(func() {})
+	state.user, state.err = user, nil
+}
+
+// UserOf returns the user making r, or nil if there is none or r is not
+// handled by the Auth middleware.
+func UserOf(r *http.Request) *User {
+	state := authStateOf(r)
+	if state == nil {
+		return nil
+	}
+	user, err := state.authenticate(r)
+	if err != nil {
+		log.Printf("Error authenticating %s %s\n\t%v\n", r.Method, r.URL.Path, err)
+	}
+	return user
+}
+
+// UserFromContext returns the user of the request of a typed handler, or nil.
+func UserFromContext(ctx context.Context) *User {
+	r := RequestFromContext(ctx)
+	if r == nil {
+		return nil
+	}
+	return UserOf(r)
+}
+
+// RequireAuth is middleware that only lets requests with a user through, for
+// APIs and handlers that guard files do not cover:
+//
+//	gomx.Handle("/admin/items", http.MethodPost, createItem, gomx.WithMiddleware(gomx.RequireAuth()))
+//
+// Browsers without a user are sent to AuthOptions.LoginURL, other clients get
+// a 401.
+func RequireAuth() Middleware {
+	return guardMiddleware(internal.Guard{Auth: true})
+}
+
+// RequireRoles is middleware that only lets requests through whose user has
+// one of roles. Users without them get a 403.
+func RequireRoles(roles ...string) Middleware {
+	return guardMiddleware(internal.Guard{Auth: true, Roles: roles})
+}
+
+func guardMiddleware(guard internal.Guard) Middleware {
+	return func(next http.Handler) http.Handler {
+		return guardHandler(next, &guard)
+	}
+}
+
+// guardHandler returns a handler that checks guard before serving next.
+func guardHandler(next http.Handler, guard *internal.Guard) http.Handler {
+	if !guard.Auth {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user *User
+		var err error
+		if state := authStateOf(r); state != nil {
+			user, err = state.authenticate(r)
+		}
+		if err != nil {
+			ReturnError(w, r, WrapHTTPError(http.StatusInternalServerError, err))
+			return
+		}
+		if user == nil {
+			denyAccess(w, r, http.StatusUnauthorized)
+			return
+		}
+		if len(guard.Roles) > 0 && !user.HasRole(guard.Roles...) {
+			denyAccess(w, r, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// denyAccess answers a request that was turned away by a guard. Requests
+// without a user are sent to AuthOptions.LoginURL: full page loads are
+// redirected, and htmx requests get HX-Redirect. API clients, and everyone
+// if there is no login URL, get a 401 asking for the credentials of the
+// authenticators. Users without a required role get a 403.
+func denyAccess(w http.ResponseWriter, r *http.Request, status int) {
+	log.Printf("%d Error: %s %s\n\taccess denied\n", status, r.Method, r.URL.Path)
+	if status == http.StatusForbidden {
+		ReturnError(w, r, Forbidden("You do not have permission to access this page."))
+		return
+	}
+	state := authStateOf(r)
+	if state != nil && state.options.LoginURL != "" && wantsHTML(r) {
+		if htmx.IsRequest(r) {
+			next := ""
+			if current := htmx.CurrentURL(r); current != nil {
+				next = current.RequestURI()
+			}
+			htmx.Redirect(w, loginURL(state.options.LoginURL, next))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next := ""
+		if r.Method == http.MethodGet {
+			next = r.URL.RequestURI()
+		}
+		http.Redirect(w, r, loginURL(state.options.LoginURL, next), http.StatusSeeOther)
+		return
+	}
+	if state != nil {
+		for _, authenticator := range state.options.Authenticators {
+			if challenger, ok := authenticator.(Challenger); ok {
+				w.Header().Add("WWW-Authenticate", challenger.Challenge())
+			}
+		}
+	}
+	ReturnError(w, r, Unauthorized("You need to sign in to access this page."))
+}
+
+// loginURL returns the login URL with next as its "next" query parameter.
+func loginURL(login string, next string) string {
+	if next == "" {
+		return login
+	}
+	u, err := url.Parse(login)
+	if err != nil {
+		return login
+	}
+	query := u.Query()
+	query.Set("next", next)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// templateUser is the user template function. It returns the user of the
+// request behind data (see requestOf), or nil:
+//
+//	{{with user .}}Signed in as {{.Name}}{{end}}
+func templateUser(data any) *User {
+	r := requestOf(data)
+	if r == nil {
+		return nil
+	}
+	return UserOf(r)
+}
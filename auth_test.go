@@ -0,0 +1,99 @@
+package gomx
+
+import (
+	"context"
+	"github.com/gomxapp/gomx/htmx"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	tokens := map[string]*User{
+		"admin-token":  {ID: "1", Name: "Ada", Roles: []string{"admin"}},
+		"viewer-token": {ID: "2", Name: "Bob"},
+	}
+	auth := Auth(AuthOptions{
+		Authenticators: []Authenticator{
+			BasicAuthenticator("staging", BasicUsers(map[string]string{"ada": "secret"}, "admin")),
+			BearerAuthenticator(func(ctx context.Context, token string) (*User, error) {
+				return tokens[token], nil
+			}),
+		},
+		LoginURL: "/login",
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(UserOf(r).Name))
+	})
+	handler := auth(RequireRoles("admin")(ok))
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		status   int
+		location string
+	}{
+		{"page", http.Header{"Accept": {"text/html"}}, http.StatusSeeOther, "/login?next=%2Fadmin%3Ftab%3D2"},
+		{"htmx", http.Header{"Hx-Request": {"true"}, "Hx-Current-Url": {"https://example.com/admin/items"}}, http.StatusUnauthorized, "/login?next=%2Fadmin%2Fitems"},
+		{"api", http.Header{"Accept": {"application/json"}}, http.StatusUnauthorized, ""},
+		{"basic", http.Header{"Authorization": {"Basic YWRhOnNlY3JldA=="}}, http.StatusOK, ""},
+		{"wrong password", http.Header{"Authorization": {"Basic YWRhOndyb25n"}}, http.StatusUnauthorized, ""},
+		{"unknown user", http.Header{"Authorization": {"Basic Ym9iOnNlY3JldA=="}}, http.StatusUnauthorized, ""},
+		{"bearer", http.Header{"Authorization": {"Bearer admin-token"}}, http.StatusOK, ""},
+		{"missing role", http.Header{"Authorization": {"Bearer viewer-token"}}, http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/admin?tab=2", nil)
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+		location := w.Header().Get("Location")
+		if htmx.IsRequest(r) {
+			location = w.Header().Get(htmx.HeaderRedirect)
+		}
+		if location != test.location {
+			t.Errorf("%s: redirected to %q, want %q", test.name, location, test.location)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if challenges := w.Header().Values("WWW-Authenticate"); len(challenges) != 2 || challenges[1] != "Bearer" {
+		t.Errorf("WWW-Authenticate = %v", challenges)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}})(Auth(AuthOptions{
+		Authenticators: []Authenticator{SessionAuthenticator()},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			err := Login(r, &User{ID: "1", Name: "Ada", Roles: []string{"admin"}})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		RequireRoles("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello " + UserOf(r).Name))
+		})).ServeHTTP(w, r)
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := w.Result().Cookies()
+	if w.Body.String() != "hello Ada" || len(cookies) != 1 {
+		t.Fatalf("login: %d %q %v", w.Code, w.Body.String(), cookies)
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	r.AddCookie(cookies[0])
+	handler.ServeHTTP(w, r)
+	if w.Body.String() != "hello Ada" {
+		t.Errorf("after login: %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,244 @@
+package gomx
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBindMemory is how much of a multipart form is kept in memory by Bind.
+const maxBindMemory = 32 << 20
+
+// maxBindBodySize limits the size of JSON bodies decoded by Bind.
+const maxBindBodySize = 10 << 20
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType        = reflect.TypeFor[time.Duration]()
+)
+
+// BindError is returned by Bind when part of the request cannot be decoded into
+// the value it is bound to.
+type BindError struct {
+	// Field is the name of the struct field, or empty for the request body.
+	Field string
+	// Source is where the value came from: "path", "query", "form", "header",
+	// or "json".
+	Source string
+	Err    error
+}
+
+func (e *BindError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s body: %v", e.Source, e.Err)
+	}
+	return fmt.Sprintf("invalid %s value for %s: %v", e.Source, e.Field, e.Err)
+}
+
+// detail describes e to clients, naming only the field and source, since Err
+// may reveal the internals of the decoder.
+func (e *BindError) detail() string {
+	if e.Field == "" {
+		return fmt.Sprintf("The %s body is invalid.", e.Source)
+	}
+	return fmt.Sprintf("The %s value for %s is invalid.", e.Source, e.Field)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Bind decodes the request into v, which must be a pointer. If the request has
+// a JSON body, it is decoded into v first. Then, if v points to a struct, its
+// fields are set from the request according to their tags, with later sources
+// taking precedence:
+//
+//	form:"name"     r.Form, which holds the query string and form body
+//	query:"name"    r.URL.Query()
+//	header:"Name"   r.Header
+//	path:"name"     r.PathValue
+//
+// Fields can be strings, booleans, numbers, time.Duration, types implementing
+// encoding.TextUnmarshaler, slices of those for repeated values, or pointers
+// to any of them. Fields of embedded structs are bound as well.
+func Bind(r *http.Request, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("bind target must be a non-nil pointer")
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" && r.Body != nil && r.Body != http.NoBody {
+		err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBindBodySize)).Decode(v)
+		if err != nil && err != io.EOF {
+			return &BindError{Source: "json", Err: err}
+		}
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	if mediaType == "multipart/form-data" {
+		err := r.ParseMultipartForm(maxBindMemory)
+		if err != nil {
+			return &BindError{Source: "form", Err: err}
+		}
+	} else {
+		err := r.ParseForm()
+		if err != nil {
+			return &BindError{Source: "form", Err: err}
+		}
+	}
+	query := r.URL.Query()
+	sources := []struct {
+		tag    string
+		lookup func(string) []string
+	}{
+		{"form", func(key string) []string { return r.Form[key] }},
+		{"query", func(key string) []string { return query[key] }},
+		{"header", func(key string) []string { return r.Header.Values(key) }},
+		{"path", func(key string) []string {
+			if value := r.PathValue(key); value != "" {
+				return []string{value}
+			}
+			return nil
+		}},
+	}
+	for _, source := range sources {
+		err := bindStruct(rv, source.tag, source.lookup)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bindStruct(rv reflect.Value, tag string, lookup func(string) []string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, ok := field.Tag.Lookup(tag)
+		if !ok {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				err := bindStruct(rv.Field(i), tag, lookup)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		key, _, _ = strings.Cut(key, ",")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+		values := lookup(key)
+		if len(values) == 0 {
+			continue
+		}
+		err := setField(rv.Field(i), values)
+		if err != nil {
+			return &BindError{Field: field.Name, Source: tag, Err: err}
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			// an empty value leaves the pointer nil, like other unset fields
+			if len(values) == 1 && values[0] == "" && field.Type().Elem().Kind() != reflect.String {
+				return nil
+			}
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setField(field.Elem(), values)
+	}
+	if field.Kind() == reflect.Slice && !reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			err := setValue(slice.Index(i), value)
+			if err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, values[0])
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), s)
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return nil
+	}
+	// an empty value leaves anything but a string unset
+	if s == "" {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		// checkboxes are sent as "on"
+		if s == "on" {
+			v.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package gomx
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Paging is exported, since Bind skips unexported embedded structs.
+type Paging struct {
+	Page uint `query:"page"`
+}
+
+type bindInput struct {
+	ID      int           `path:"id"`
+	Tags    []string      `query:"tag"`
+	Limit   *int          `query:"limit"`
+	Timeout time.Duration `query:"timeout"`
+	Name    string        `form:"name"`
+	Agree   bool          `form:"agree"`
+	Score   float64       `form:"score"`
+	Token   string        `header:"X-Token"`
+	Count   int           `json:"count"`
+	// query values take precedence over the JSON body
+	Title  string    `json:"title" query:"title"`
+	Since  time.Time `query:"since"`
+	Ignore string    `query:"-"`
+	Paging
+}
+
+func TestBind(t *testing.T) {
+	limit := 10
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		header      http.Header
+		pathID      string
+		want        bindInput
+	}{
+		{name: "path", method: http.MethodGet, target: "/items/4", pathID: "4", want: bindInput{ID: 4}},
+		{name: "query", method: http.MethodGet, target: "/?tag=a&tag=b&limit=10&timeout=1m30s&page=3&since=2024-05-01T00:00:00Z&Ignore=x",
+			want: bindInput{Tags: []string{"a", "b"}, Limit: &limit, Timeout: 90 * time.Second, Since: since, Paging: Paging{Page: 3}}},
+		{name: "form", method: http.MethodPost, target: "/", contentType: "application/x-www-form-urlencoded", body: "name=Ada&agree=on&score=2.5",
+			want: bindInput{Name: "Ada", Agree: true, Score: 2.5}},
+		{name: "empty values", method: http.MethodPost, target: "/?limit=", contentType: "application/x-www-form-urlencoded", body: "name=&score=",
+			want: bindInput{}},
+		{name: "header", method: http.MethodGet, target: "/", header: http.Header{"X-Token": {"t0k"}}, want: bindInput{Token: "t0k"}},
+		{name: "json", method: http.MethodPost, target: "/?title=query", contentType: "application/json", body: `{"count": 2, "title": "body"}`,
+			want: bindInput{Count: 2, Title: "query"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		if test.pathID != "" {
+			r.SetPathValue("id", test.pathID)
+		}
+		var in bindInput
+		err := Bind(r, &in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(in, test.want) {
+			t.Errorf("%s: bound %+v, want %+v", test.name, in, test.want)
+		}
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		pathID      string
+		field       string
+		source      string
+	}{
+		{name: "path", target: "/items/x", pathID: "x", field: "ID", source: "path"},
+		{name: "query", target: "/?limit=ten", field: "Limit", source: "query"},
+		{name: "duration", target: "/?timeout=soon", field: "Timeout", source: "query"},
+		{name: "text unmarshaler", target: "/?since=yesterday", field: "Since", source: "query"},
+		{name: "negative uint", target: "/?page=-1", field: "Page", source: "query"},
+		{name: "form", target: "/", contentType: "application/x-www-form-urlencoded", body: "agree=maybe", field: "Agree", source: "form"},
+		{name: "json syntax", target: "/", contentType: "application/json", body: `{"count": `, source: "json"},
+		{name: "json type", target: "/", contentType: "application/json", body: `{"count": "two"}`, source: "json"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		if test.pathID != "" {
+			r.SetPathValue("id", test.pathID)
+		}
+		var in bindInput
+		err := Bind(r, &in)
+		var bindErr *BindError
+		if !errors.As(err, &bindErr) || bindErr.Field != test.field || bindErr.Source != test.source {
+			t.Errorf("%s: error = %v, want a %s error for %q", test.name, err, test.source, test.field)
+		}
+	}
+
+	var in bindInput
+	if err := Bind(httptest.NewRequest(http.MethodGet, "/", nil), in); err == nil {
+		t.Error("binding into a non-pointer should fail")
+	}
+}
@@ -0,0 +1,191 @@
+package gomx
+
+import (
+	"fmt"
+	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/internal"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CachePolicy is how the responses of a route are cached.
+type CachePolicy struct {
+	// CacheControl is sent as the Cache-Control header of successful and not
+	// modified responses, unless the handler sets one itself.
+	CacheControl string
+	// ETag generates an ETag from the body of responses that are written in
+	// one piece with a Content-Length, like those of ReturnGoHTML,
+	// ReturnJSONValue, typed handlers, and pages, and from the modification
+	// time and size of static files.
+	ETag bool
+}
+
+// Cache is middleware that applies policy to the routes it wraps, replacing
+// the default of the "etag" setting in gomx.config.json. Pass it when
+// registering an API, or to Router.UseDir for static files:
+//
+//	gomx.RegisterOnPath("/items", http.MethodGet, listItems, gomx.Cache(gomx.CachePolicy{
+//		CacheControl: "private, no-cache",
+//		ETag:         true,
+//	}))
+//
+// Pages set theirs with the "cache-control" and "etag" front matter keys.
+func Cache(policy CachePolicy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return conditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			options := internal.CacheOptionsOf(r)
+			options.ETag = policy.ETag
+			options.CacheControl = policy.CacheControl
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// conditional answers GET and HEAD requests with 304 Not Modified when their
+// If-None-Match or If-Modified-Since header matches the ETag or Last-Modified
+// header of the response, and generates ETags as the request's
+// internal.CacheOptions ask. The router wraps every request in it.
+func conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if internal.CacheOptionsOf(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		options := &internal.CacheOptions{ETag: config.ETags}
+		r = internal.WithCacheOptions(r, options)
+		cw := &conditionalWriter{ResponseWriter: w, r: r, options: options}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// conditionalWriter holds back the header of 200 responses to GET and HEAD
+// requests until the first write, when the ETag can be generated from the body
+// and the request's preconditions checked.
+type conditionalWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	options     *internal.CacheOptions
+	wroteHeader bool
+	pending     bool
+	notModified bool
+}
+
+func (cw *conditionalWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.wroteHeader = true
+	if status == http.StatusOK && (cw.r.Method == http.MethodGet || cw.r.Method == http.MethodHead) {
+		cw.pending = true
+		return
+	}
+	cw.writeHeader(status)
+}
+
+func (cw *conditionalWriter) writeHeader(status int) {
+	header := cw.Header()
+	if cw.options.CacheControl != "" && header.Get("Cache-Control") == "" &&
+		(status >= 200 && status < 300 || status == http.StatusNotModified) {
+		header.Set("Cache-Control", cw.options.CacheControl)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *conditionalWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending {
+		cw.decide(p)
+	}
+	if cw.notModified {
+		return len(p), nil
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide writes the held back header, or a 304 if the request's preconditions
+// match. body is the first write, which is the whole body if it is as long as
+// the Content-Length, or nil if it is unknown.
+func (cw *conditionalWriter) decide(body []byte) {
+	cw.pending = false
+	header := cw.Header()
+	if cw.options.ETag && body != nil && header.Get("ETag") == "" &&
+		header.Get("Content-Length") == strconv.Itoa(len(body)) {
+		header.Set("ETag", internal.ETag(body))
+	}
+	if notModified(cw.r, header) {
+		cw.notModified = true
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		cw.writeHeader(http.StatusNotModified)
+		return
+	}
+	cw.writeHeader(http.StatusOK)
+}
+
+func (cw *conditionalWriter) Flush() {
+	if cw.pending {
+		cw.decide(nil)
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *conditionalWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *conditionalWriter) close() {
+	if cw.pending {
+		cw.decide([]byte{})
+	}
+}
+
+// notModified returns whether r is a GET or HEAD request whose If-None-Match
+// header matches the ETag in header or, without If-None-Match, whose
+// If-Modified-Since is not before the Last-Modified in header.
+func notModified(r *http.Request, header http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		return etag != "" && etagMatches(ifNoneMatch, etag)
+	}
+	ifModifiedSince, lastModified := r.Header.Get("If-Modified-Since"), header.Get("Last-Modified")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(since)
+}
+
+// etagMatches compares etag to the list of an If-None-Match header, using the
+// weak comparison, so a compressed response still matches.
+func etagMatches(list string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// fileETag returns an ETag made from the modification time and size of a file.
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
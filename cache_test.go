@@ -0,0 +1,104 @@
+package gomx
+
+import (
+	"github.com/gomxapp/gomx/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheETag(t *testing.T) {
+	handler := Cache(CachePolicy{CacheControl: "private, no-cache", ETag: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := ReturnGoHTML(w, "<p>{{.}}</p>", r.URL.Query().Get("name"))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?name=ada", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != "private, no-cache" || w.Body.String() != "<p>ada</p>" {
+		t.Fatalf("got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"other", W/` + etag, "*"} {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?name=ada", nil)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag ||
+			w.Header().Get("Cache-Control") != "private, no-cache" || w.Header().Get("Content-Type") != "" {
+			t.Errorf("If-None-Match %s: got %d %v %q", ifNoneMatch, w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/?name=grace", nil)
+	r.Header.Set("If-None-Match", etag)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag || w.Body.String() != "<p>grace</p>" {
+		t.Errorf("changed body: got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestCacheLastModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	handler := conditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		_, _ = w.Write([]byte("report"))
+	}))
+	tests := []struct {
+		ifModifiedSince time.Time
+		status          int
+	}{
+		{modified, http.StatusNotModified},
+		{modified.Add(time.Hour), http.StatusNotModified},
+		{modified.Add(-time.Hour), http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/report", nil)
+		r.Header.Set("If-Modified-Since", test.ifModifiedSince.Format(http.TimeFormat))
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("If-Modified-Since %v: status = %d, want %d", test.ifModifiedSince, w.Code, test.status)
+		}
+	}
+
+	// preconditions only apply to GET and HEAD
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/report", nil)
+	r.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "report" {
+		t.Errorf("POST: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestStaticHandlerETag(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "app.css"), []byte("body { color: red }\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Cache(CachePolicy{ETag: true})(newStaticHandler("static", http.Dir(dir), internal.NewAssetRegistry()))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app.css", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+	r.Header.Set("If-None-Match", etag)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want 304", w.Code)
+	}
+}
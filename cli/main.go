@@ -1,14 +1,22 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
 	"text/template"
 )
 
@@ -30,6 +38,165 @@ func main() {
 		}
 		fmt.Println("Done!")
 	}
+
+	if flag.Arg(0) == "assets" {
+		err := writeAssetManifest(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Done!")
+	}
+
+	if flag.Arg(0) == "openapi" {
+		err := exportOpenAPI(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Done!")
+	}
+}
+
+// openAPIExportEnv must match gomx.OpenAPIExportEnv. The CLI is its own module,
+// so it does not import gomx.
+const openAPIExportEnv = "GOMX_OPENAPI_EXPORT"
+
+// exportOpenAPI writes the OpenAPI document of the app in the current
+// directory to a file. It runs the app with openAPIExportEnv set, which makes
+// the router write the document when it is initialized and exit.
+//
+// Example: gomx openapi -o docs/openapi.json
+func exportOpenAPI(args []string) error {
+	flags := flag.NewFlagSet("openapi", flag.ExitOnError)
+	out := flags.String("o", "openapi.json", "file to write the OpenAPI document to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	outFile, err := filepath.Abs(*out)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(outFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	fmt.Println("Exporting OpenAPI document to " + outFile)
+	cmd := exec.Command("go", "run", ".")
+	cmd.Env = append(os.Environ(), openAPIExportEnv+"="+outFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(outFile)
+	if err != nil {
+		return errors.New("the app exited without exporting an OpenAPI document. Does it initialize a router?")
+	}
+	return nil
+}
+
+// assetConfig is the part of gomx.config.json that locates the asset manifest.
+type assetConfig struct {
+	AppRoot string `json:"appRoot"`
+	Assets  struct {
+		Manifest string `json:"manifest"`
+	} `json:"assets"`
+}
+
+// readAssetConfig reads gomx.config.json in the current directory, if there is
+// one, with the same defaults as gomx.
+func readAssetConfig() (assetConfig, error) {
+	var c assetConfig
+	c.AppRoot = "./app"
+	c.Assets.Manifest = "assets.json"
+	data, err := os.ReadFile("gomx.config.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// fingerprint must match internal.Fingerprint in gomx.
+func fingerprint(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:5])
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// writeAssetManifest writes the fingerprinted names of the files in the given
+// static directories, relative to the app root, to the asset manifest, so the
+// app does not hash them when it starts. Run it whenever the assets change,
+// e.g. after building CSS for a deploy.
+//
+// Example: gomx assets static
+func writeAssetManifest(args []string) error {
+	flags := flag.NewFlagSet("assets", flag.ExitOnError)
+	out := flags.String("o", "", "file to write the manifest to (default: the manifest in gomx.config.json)")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	c, err := readAssetConfig()
+	if err != nil {
+		return err
+	}
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"static"}
+	}
+	manifest, err := assetManifest(c.AppRoot, dirs)
+	if err != nil {
+		return err
+	}
+	outFile := *out
+	if outFile == "" {
+		outFile = filepath.Join(c.AppRoot, c.Assets.Manifest)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Writing %d assets to %s\n", len(manifest), outFile)
+	return os.WriteFile(outFile, append(data, '\n'), 0664)
+}
+
+// assetManifest maps the name of every file in dirs, except precompressed .gz
+// files, to its fingerprinted name.
+func assetManifest(appRoot string, dirs []string) (map[string]string, error) {
+	manifest := make(map[string]string)
+	for _, dir := range dirs {
+		dir = strings.Trim(path.Clean("/"+filepath.ToSlash(dir)), "/")
+		root := filepath.Join(appRoot, dir)
+		err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".gz") {
+				return nil
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			name := dir + "/" + filepath.ToSlash(rel)
+			manifest[name] = fingerprint(name, data)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
 }
 
 const templateFileServer = "http://localhost:8081"
@@ -8,3 +8,11 @@ import (
 func Test_getTemplateFile(t *testing.T) {
 	getTemplateFile("go.mod")
 }
+
+func Test_fingerprint(t *testing.T) {
+	// must match internal.Fingerprint in gomx
+	got := fingerprint("static/output.css", []byte("body{}"))
+	if got != "static/output.7c98040a54.css" {
+		t.Errorf("fingerprint = %s", got)
+	}
+}
@@ -0,0 +1,310 @@
+package gomx
+
+import (
+	"compress/gzip"
+	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/internal"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		gz, err := gzip.NewWriterLevel(io.Discard, config.CompressionLevel)
+		if err != nil {
+			// the level is checked when the config is read
+			gz = gzip.NewWriter(io.Discard)
+		}
+		return gz
+	},
+}
+
+// acceptsGzip returns whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			q := 1.0
+			if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				gzipQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ != -1 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// compressible returns whether responses with contentType are compressed.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return slices.Contains(config.CompressionTypes, mediaType)
+}
+
+// addVary adds value to the Vary header unless it is already listed.
+func addVary(header http.Header, value string) {
+	for _, v := range header.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// Compress is middleware that compresses responses with gzip when the client
+// accepts it, the Content-Type is one of the configured types, and the body is
+// at least the configured minimum size. Responses that vary with
+// Accept-Encoding get a Vary header, and a strong ETag becomes weak when the
+// body is compressed. Event streams, WebSocket handshakes, partial content, and
+// responses that already have a Content-Encoding are left as they are.
+//
+// The router adds Compress to every request unless compression is disabled in
+// gomx.config.json:
+//
+//	"compression": {"enabled": true, "minSize": 1024, "level": 6, "contentTypes": ["text/html", "application/json"]}
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(*compressWriter); ok ||
+			headerContainsToken(r.Header, "Connection", "upgrade") ||
+			r.Header.Get("Accept") == "text/event-stream" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			accepts:        acceptsGzip(r),
+			status:         http.StatusOK,
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the header and the start of the body until it
+// knows whether to compress: when the body reaches the minimum size, when it
+// is flushed, or when the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	accepts     bool
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	// informational headers are sent right away
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.wroteHeader = true
+	if cw.decided {
+		if cw.gz != nil {
+			return cw.gz.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= config.CompressionMinSize {
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the header and the held back body, compressed if the response
+// qualifies. Bodies below the minimum size only qualify if bigEnough is set.
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 && header.Get("Content-Encoding") == "" {
+		// what the http package would do, done here to check the type
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	eligible := cw.status != http.StatusNoContent &&
+		cw.status != http.StatusNotModified &&
+		cw.status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		header.Get("Content-Range") == "" &&
+		compressible(header.Get("Content-Type"))
+	if eligible {
+		addVary(header, "Accept-Encoding")
+	}
+	if eligible && cw.accepts && bigEnough {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+		cw.ResponseWriter.WriteHeader(cw.status)
+		_, err := cw.gz.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	var err error
+	if len(cw.buf) > 0 {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Flush sends what has been written so far. A flushed response is compressed
+// even if it is still below the minimum size, since more is likely to follow.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		_ = cw.decide(true)
+	}
+	if cw.gz != nil {
+		_ = cw.gz.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// the handler wrote nothing, let the server send its default
+			return
+		}
+		_ = cw.decide(len(cw.buf) >= config.CompressionMinSize)
+	}
+	if cw.gz != nil {
+		_ = cw.gz.Close()
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	}
+}
+
+// staticHandler serves the files in root like http.FileServer, but serves a
+// precompressed file.gz in place of file when the client accepts gzip, and
+// sends ETags made from the files' modification times when enabled. Files
+// requested by their fingerprinted name are sent with immutable caching.
+type staticHandler struct {
+	// dir is the directory under config.AppRootDir that root serves.
+	dir        string
+	root       http.FileSystem
+	fileServer http.Handler
+	// assets resolve fingerprinted names to the files they name.
+	assets *internal.AssetRegistry
+}
+
+func newStaticHandler(dir string, root http.FileSystem, assets *internal.AssetRegistry) *staticHandler {
+	return &staticHandler{
+		dir:        strings.Trim(path.Clean("/"+dir), "/"),
+		root:       root,
+		fileServer: http.FileServer(root),
+		assets:     assets,
+	}
+}
+
+func (sh *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sh.fileServer.ServeHTTP(w, r)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	if asset, ok := sh.assets.Resolve(sh.dir + name); ok {
+		name = strings.TrimPrefix(asset, sh.dir)
+		r = r.Clone(r.Context())
+		r.URL.Path = name
+		w.Header().Set("Cache-Control", internal.ImmutableCacheControl)
+	}
+	if config.CompressionPrecompressed && sh.servePrecompressed(w, r, name) {
+		return
+	}
+	if options := internal.CacheOptionsOf(r); options != nil && options.ETag {
+		if info, ok := sh.stat(name); ok {
+			w.Header().Set("ETag", fileETag(info))
+		}
+	}
+	sh.fileServer.ServeHTTP(w, r)
+}
+
+// servePrecompressed serves name.gz if it exists and the client accepts gzip.
+// It returns whether it served the request.
+func (sh *staticHandler) servePrecompressed(w http.ResponseWriter, r *http.Request, name string) bool {
+	if _, ok := sh.stat(name + ".gz"); !ok {
+		return false
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return false
+	}
+	f, err := sh.root.Open(name + ".gz")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", "gzip")
+	if options := internal.CacheOptionsOf(r); options != nil && options.ETag {
+		w.Header().Set("ETag", fileETag(info))
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+	return true
+}
+
+// stat returns the info of name if it is a regular file.
+func (sh *staticHandler) stat(name string) (fs.FileInfo, bool) {
+	f, err := sh.root.Open(name)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	return info, true
+}
@@ -0,0 +1,140 @@
+package gomx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/gomxapp/gomx/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("<p>hello</p>", 200)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		compressed     bool
+		vary           bool
+	}{
+		{"gzip", "gzip, deflate, br", "text/html; charset=utf-8", large, true, true},
+		{"q0", "gzip;q=0, *", "text/html; charset=utf-8", large, false, true},
+		{"wildcard", "br;q=1, *;q=0.5", "text/html; charset=utf-8", large, true, true},
+		{"none", "", "text/html; charset=utf-8", large, false, true},
+		{"small", "gzip", "text/html; charset=utf-8", "<p>hello</p>", false, true},
+		{"type", "gzip", "image/png", large, false, false},
+		{"sniffed", "gzip", "", large, true, true},
+	}
+	for _, test := range tests {
+		handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.contentType != "" {
+				w.Header().Set("Content-Type", test.contentType)
+			}
+			w.Header().Set("ETag", `"abc"`)
+			// written in pieces to cross the minimum size mid-response
+			for i := 0; i < len(test.body); i += 100 {
+				_, _ = io.WriteString(w, test.body[i:min(i+100, len(test.body))])
+			}
+		}))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		handler.ServeHTTP(w, r)
+		body := w.Body.String()
+		if test.compressed {
+			if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("ETag") != `W/"abc"` {
+				t.Errorf("%s: header = %v", test.name, w.Header())
+			}
+			body = gunzip(t, w.Body.Bytes())
+		} else if w.Header().Get("Content-Encoding") != "" || w.Header().Get("ETag") != `"abc"` {
+			t.Errorf("%s: header = %v", test.name, w.Header())
+		}
+		if body != test.body {
+			t.Errorf("%s: body = %q", test.name, body)
+		}
+		if vary := w.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Errorf("%s: Vary = %q", test.name, w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = io.WriteString(w, "<p>first</p>")
+		err := http.NewResponseController(w).Flush()
+		if err != nil {
+			t.Error(err)
+		}
+		_, _ = io.WriteString(w, "<p>second</p>")
+	}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted || !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	if body := gunzip(t, w.Body.Bytes()); body != "<p>first</p><p>second</p>" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestStaticHandlerPrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	script := "console.log('hello')\n"
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = io.WriteString(gz, script)
+	_ = gz.Close()
+	for name, data := range map[string][]byte{
+		"app.js":    []byte(script),
+		"app.js.gz": gzipped.Bytes(),
+	} {
+		err := os.WriteFile(filepath.Join(dir, name), data, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := Compress(newStaticHandler("static", http.Dir(dir), internal.NewAssetRegistry()))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatalf("header = %v", w.Header())
+	}
+	if !bytes.Equal(w.Body.Bytes(), gzipped.Bytes()) {
+		t.Errorf("body is not the precompressed file")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" || w.Body.String() != script {
+		t.Errorf("got %v %q", w.Header(), w.Body.String())
+	}
+}
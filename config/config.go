@@ -1,11 +1,14 @@
 package config
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var AppRootDir string
@@ -13,13 +16,60 @@ var ApiRootDir string
 var RoutesDir string
 var ReservedDir string
 var BaseTemplate string
+var DevMode bool
+var OpenAPIPath string
+var OpenAPITitle string
+var OpenAPIVersion string
+var ETags bool
+var AssetManifest = "assets.json"
+var CompressionEnabled = true
+var CompressionLevel = gzip.DefaultCompression
+var CompressionMinSize = 1024
+var CompressionTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/problem+json",
+	"application/xml",
+	"image/svg+xml",
+	"text/css",
+	"text/html",
+	"text/javascript",
+	"text/plain",
+	"text/xml",
+}
+var CompressionPrecompressed = true
+var TrustedProxies []netip.Prefix
 
 type config struct {
-	AppRootDir   string `json:"appRoot"`
-	ApiRootDir   string `json:"apiRoot"`
-	RoutesDir    string `json:"routes"`
-	ReservedDir  string `json:"reserved"`
-	BaseTemplate string `json:"baseTemplate"`
+	AppRootDir     string            `json:"appRoot"`
+	ApiRootDir     string            `json:"apiRoot"`
+	RoutesDir      string            `json:"routes"`
+	ReservedDir    string            `json:"reserved"`
+	BaseTemplate   string            `json:"baseTemplate"`
+	DevMode        bool              `json:"dev"`
+	ETags          bool              `json:"etag"`
+	OpenAPI        openAPIConfig     `json:"openapi"`
+	Compression    compressionConfig `json:"compression"`
+	Assets         assetsConfig      `json:"assets"`
+	TrustedProxies []string          `json:"trustedProxies"`
+}
+
+type openAPIConfig struct {
+	Path    string `json:"path"`
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type assetsConfig struct {
+	Manifest string `json:"manifest"`
+}
+
+type compressionConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Level         int      `json:"level"`
+	MinSize       int      `json:"minSize"`
+	ContentTypes  []string `json:"contentTypes"`
+	Precompressed bool     `json:"precompressed"`
 }
 
 var defaultConfig = config{
@@ -28,6 +78,20 @@ var defaultConfig = config{
 	RoutesDir:    "/routes",
 	ReservedDir:  "/_",
 	BaseTemplate: "/index.gohtml",
+	OpenAPI: openAPIConfig{
+		Title:   "GOMX API",
+		Version: "0.0.0",
+	},
+	Assets: assetsConfig{
+		Manifest: AssetManifest,
+	},
+	Compression: compressionConfig{
+		Enabled:       CompressionEnabled,
+		Level:         CompressionLevel,
+		MinSize:       CompressionMinSize,
+		ContentTypes:  CompressionTypes,
+		Precompressed: CompressionPrecompressed,
+	},
 }
 
 func Init() {
@@ -64,6 +128,49 @@ func Init() {
 		BaseTemplate = filepath.Join(AppRootDir, BaseTemplate)
 		BaseTemplate = filepath.ToSlash(filepath.Clean(BaseTemplate))
 		fmt.Printf("\"baseTemplate\" = %s\n", BaseTemplate)
+		DevMode = c.DevMode
+		fmt.Printf("\"dev\" = %t\n", DevMode)
+		ETags = c.ETags
+		fmt.Printf("\"etag\" = %t\n", ETags)
+		if c.OpenAPI.Path != "" {
+			OpenAPIPath = "/" + strings.Trim(c.OpenAPI.Path, "/")
+		}
+		if c.OpenAPI.Title != "" {
+			OpenAPITitle = c.OpenAPI.Title
+		}
+		if c.OpenAPI.Version != "" {
+			OpenAPIVersion = c.OpenAPI.Version
+		}
+		fmt.Printf("\"openapi\" = %s\n", OpenAPIPath)
+		if c.Assets.Manifest != "" {
+			AssetManifest = filepath.ToSlash(filepath.Clean(c.Assets.Manifest))
+		}
+		fmt.Printf("\"assets\" = %s\n", AssetManifest)
+		CompressionEnabled = c.Compression.Enabled
+		if c.Compression.Level < gzip.HuffmanOnly || c.Compression.Level > gzip.BestCompression {
+			fmt.Printf("Invalid compression level %d, using the default.\n", c.Compression.Level)
+			c.Compression.Level = gzip.DefaultCompression
+		}
+		CompressionLevel = c.Compression.Level
+		if c.Compression.MinSize >= 0 {
+			CompressionMinSize = c.Compression.MinSize
+		}
+		CompressionTypes = CompressionTypes[:0:0]
+		for _, contentType := range c.Compression.ContentTypes {
+			CompressionTypes = append(CompressionTypes, strings.ToLower(strings.TrimSpace(contentType)))
+		}
+		CompressionPrecompressed = c.Compression.Precompressed
+		fmt.Printf("\"compression\" = %t\n", CompressionEnabled)
+		TrustedProxies = TrustedProxies[:0:0]
+		for _, proxy := range c.TrustedProxies {
+			prefix, err := parsePrefix(strings.TrimSpace(proxy))
+			if err != nil {
+				fmt.Printf("Invalid trusted proxy %q, skipping it.\n", proxy)
+				continue
+			}
+			TrustedProxies = append(TrustedProxies, prefix)
+		}
+		fmt.Printf("\"trustedProxies\" = %v\n", TrustedProxies)
 	}()
 
 	data, err := os.ReadFile("gomx.config.json")
@@ -81,3 +188,16 @@ func Init() {
 		return
 	}
 }
+
+// parsePrefix parses a CIDR range or a single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
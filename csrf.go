@@ -0,0 +1,230 @@
+package gomx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csrfSecretSize is the size of the secret in the CSRF cookie.
+const csrfSecretSize = 32
+
+// CSRFOptions configure CSRF.
+type CSRFOptions struct {
+	// CookieName defaults to "gomx_csrf".
+	CookieName string
+	// FieldName is the form field holding the token. It defaults to
+	// "csrf_token".
+	FieldName string
+	// HeaderName is the request header holding the token. It defaults to
+	// "X-CSRF-Token".
+	HeaderName string
+	// MaxAge is how long the cookie lasts. It defaults to 30 days.
+	MaxAge time.Duration
+	// Secure cookies are only sent over HTTPS. Cookies are always secure on
+	// requests that came over TLS.
+	Secure bool
+	// ExemptPaths are paths whose requests are not checked if they carry an
+	// "Authorization: Bearer" header, for APIs used by other programs rather
+	// than browsers, which do not send such headers on their own. A path
+	// ending in "/" exempts everything below it.
+	ExemptPaths []string
+}
+
+// csrfState is the CSRF secret of a request and the token made from it.
+type csrfState struct {
+	options *CSRFOptions
+	secret  []byte
+	once    sync.Once
+	token   string
+}
+
+// CSRF is middleware that protects against cross-site request forgery. It
+// gives every client a secret in a cookie, and rejects POST, PUT, PATCH, and
+// DELETE requests, among others, that do not carry a token made from it in
+// the X-CSRF-Token header or the csrf_token form field. Rejected requests get
+// a 403 from ReturnError.
+//
+// Templates get the token from the csrfToken, csrfField, and csrfHeaders
+// functions. Setting hx-headers in the base template adds the header to every
+// htmx request:
+//
+//	<body hx-headers='{{csrfHeaders .}}'>
+//
+// Plain forms need the field:
+//
+//	<form method="post">{{csrfField .}}...</form>
+func CSRF(options CSRFOptions) Middleware {
+	if options.CookieName == "" {
+		options.CookieName = "gomx_csrf"
+	}
+	if options.FieldName == "" {
+		options.FieldName = "csrf_token"
+	}
+	if options.HeaderName == "" {
+		options.HeaderName = "X-CSRF-Token"
+	}
+	if options.MaxAge == 0 {
+		options.MaxAge = 30 * 24 * time.Hour
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if csrfStateOf(r) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			state := &csrfState{options: &options}
+			cookie, err := r.Cookie(options.CookieName)
+			if err == nil {
+				state.secret, err = base64.RawURLEncoding.DecodeString(cookie.Value)
+			}
+			if err != nil || len(state.secret) != csrfSecretSize {
+				state.secret = make([]byte, csrfSecretSize)
+				_, err = rand.Read(state.secret)
+				if err != nil {
+					ReturnError(w, r, err)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     options.CookieName,
+					Value:    base64.RawURLEncoding.EncodeToString(state.secret),
+					Path:     "/",
+					MaxAge:   int(options.MaxAge / time.Second),
+					Secure:   options.Secure || r.TLS != nil,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			r = r.WithContext(context.WithValue(r.Context(), csrfContextKey, state))
+			if !isSafeMethod(r.Method) && !options.exempt(r) && !state.valid(r) {
+				log.Printf("403 Error: %s %s\n\tinvalid CSRF token\n", r.Method, r.URL.Path)
+				ReturnError(w, r, Forbidden("The CSRF token is missing or invalid. Reload the page and try again."))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isSafeMethod returns whether method is one that must not change anything.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (options *CSRFOptions) exempt(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	for _, path := range options.ExemptPaths {
+		if r.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// valid returns whether r carries a token made from the secret.
+func (state *csrfState) valid(r *http.Request) bool {
+	token := r.Header.Get(state.options.HeaderName)
+	if token == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "multipart/form-data":
+			if r.ParseMultipartForm(maxBindMemory) == nil {
+				token = r.PostForm.Get(state.options.FieldName)
+			}
+		case "application/x-www-form-urlencoded":
+			if r.ParseForm() == nil {
+				token = r.PostForm.Get(state.options.FieldName)
+			}
+		}
+	}
+	masked, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(masked) != 2*csrfSecretSize {
+		return false
+	}
+	// a token is a random pad followed by the secret XORed with the pad
+	secret := make([]byte, csrfSecretSize)
+	subtle.XORBytes(secret, masked[:csrfSecretSize], masked[csrfSecretSize:])
+	return subtle.ConstantTimeCompare(secret, state.secret) == 1
+}
+
+func csrfStateOf(r *http.Request) *csrfState {
+	state, _ := r.Context().Value(csrfContextKey).(*csrfState)
+	return state
+}
+
+// CSRFToken returns the CSRF token for r, or an empty string if r is not
+// handled by the CSRF middleware. Tokens are masked with a random pad, so they
+// differ from response to response while the secret stays the same, which
+// keeps them from being guessed through compression (BREACH).
+func CSRFToken(r *http.Request) string {
+	state := csrfStateOf(r)
+	if state == nil {
+		return ""
+	}
+	state.once.Do(func() {
+		masked := make([]byte, 2*csrfSecretSize)
+		_, err := rand.Read(masked[:csrfSecretSize])
+		if err != nil {
+			log.Printf("Error creating CSRF token\n\t%v\n", err)
+			return
+		}
+		subtle.XORBytes(masked[csrfSecretSize:], masked[:csrfSecretSize], state.secret)
+		state.token = base64.RawURLEncoding.EncodeToString(masked)
+	})
+	return state.token
+}
+
+// templateCSRFToken is the csrfToken template function.
+func templateCSRFToken(data any) string {
+	r := requestOf(data)
+	if r == nil {
+		return ""
+	}
+	return CSRFToken(r)
+}
+
+// templateCSRFField is the csrfField template function. It returns a hidden
+// input holding the token.
+func templateCSRFField(data any) template.HTML {
+	r := requestOf(data)
+	if r == nil {
+		return ""
+	}
+	state := csrfStateOf(r)
+	if state == nil {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(state.options.FieldName) +
+		`" value="` + CSRFToken(r) + `">`)
+}
+
+// templateCSRFHeaders is the csrfHeaders template function. It returns the
+// JSON for hx-headers.
+func templateCSRFHeaders(data any) string {
+	r := requestOf(data)
+	if r == nil {
+		return "{}"
+	}
+	state := csrfStateOf(r)
+	if state == nil {
+		return "{}"
+	}
+	headers, _ := json.Marshal(map[string]string{state.options.HeaderName: CSRFToken(r)})
+	return string(headers)
+}
@@ -0,0 +1,89 @@
+package gomx
+
+import (
+	"github.com/gomxapp/gomx/internal"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	templ := template.Must(template.New("page").Funcs(template.FuncMap{
+		"csrfField":   templateCSRFField,
+		"csrfHeaders": templateCSRFHeaders,
+	}).Parse(`<body hx-headers='{{csrfHeaders .}}'><form method="post">{{csrfField .}}</form></body>`))
+	var formName string
+	handler := CSRF(CSRFOptions{ExemptPaths: []string{"/api/"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		formName = r.PostFormValue("name")
+		err := templ.Execute(w, internal.PageData{Request: r})
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != "gomx_csrf" || !cookies[0].HttpOnly {
+		t.Fatalf("got %d %v", w.Code, cookies)
+	}
+	page := w.Body.String()
+	field := regexp.MustCompile(`<input type="hidden" name="csrf_token" value="([\w-]+)">`).FindStringSubmatch(page)
+	header := regexp.MustCompile(`hx-headers='{&#34;X-CSRF-Token&#34;:&#34;([\w-]+)&#34;}'`).FindStringSubmatch(page)
+	if field == nil || header == nil {
+		t.Fatalf("page = %s", page)
+	}
+	token := field[1]
+	if header[1] != token {
+		t.Errorf("header token %s differs from field token %s", header[1], token)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	handler.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 0 || strings.Contains(w.Body.String(), token) {
+		t.Errorf("second page should keep the cookie and mask the token anew: %v", w.Result().Cookies())
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		form   url.Values
+		status int
+	}{
+		{"no token", "/items", nil, nil, http.StatusForbidden},
+		{"header", "/items", http.Header{"X-Csrf-Token": {token}}, nil, http.StatusOK},
+		{"field", "/items", nil, url.Values{"csrf_token": {token}, "name": {"x"}}, http.StatusOK},
+		{"wrong token", "/items", http.Header{"X-Csrf-Token": {strings.Repeat("A", len(token))}}, nil, http.StatusForbidden},
+		{"exempt with bearer", "/api/items", http.Header{"Authorization": {"Bearer abc"}}, nil, http.StatusOK},
+		{"exempt without bearer", "/api/items", nil, nil, http.StatusForbidden},
+		{"bearer elsewhere", "/items", http.Header{"Authorization": {"Bearer abc"}}, nil, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		var r *http.Request
+		if test.form != nil {
+			r = httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(http.MethodDelete, test.target, nil)
+		}
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		r.AddCookie(cookies[0])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+		if test.form != nil && test.status == http.StatusOK && formName != "x" {
+			t.Errorf("%s: the form is no longer readable", test.name)
+		}
+	}
+}
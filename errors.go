@@ -0,0 +1,201 @@
+package gomx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomxapp/gomx/internal"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+const problemContentType = "application/problem+json"
+
+// ProblemTemplate is the shared partial used to render errors for htmx and
+// other HTML requests, with a Problem as its data. If no partial has this
+// name, a small built-in fragment is used.
+var ProblemTemplate = "problem"
+
+var defaultProblemTemplate = template.Must(template.New("problem").Parse(
+	`<div class="gomx-problem" role="alert"><strong>{{.Title}}</strong>{{with .Detail}} {{.}}{{end}}` +
+		`{{with .Errors}}<ul>{{range $field, $message := .}}<li>{{if $field}}{{$field}} {{end}}{{$message}}</li>{{end}}</ul>{{end}}</div>`))
+
+// HTTPError is an error with the status code of the response it should
+// produce. Handlers can return one, and ReturnError, ErrorHandlerFunc, and
+// typed handlers respond with its status and detail.
+type HTTPError struct {
+	Status int
+	// Detail is shown to the client. It defaults to the status text.
+	Detail string
+	// Err is the cause. It is logged, but never shown to the client.
+	Err error
+}
+
+// NewHTTPError returns an HTTPError with a detail shown to the client.
+func NewHTTPError(status int, detail string) *HTTPError {
+	return &HTTPError{Status: status, Detail: detail}
+}
+
+// WrapHTTPError returns an HTTPError for err with the given status. Only the
+// status text is shown to the client; err is logged.
+func WrapHTTPError(status int, err error) *HTTPError {
+	return &HTTPError{Status: status, Err: err}
+}
+
+func BadRequest(detail string) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, detail)
+}
+
+func Unauthorized(detail string) *HTTPError {
+	return NewHTTPError(http.StatusUnauthorized, detail)
+}
+
+func Forbidden(detail string) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, detail)
+}
+
+func NotFound(detail string) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, detail)
+}
+
+func Conflict(detail string) *HTTPError {
+	return NewHTTPError(http.StatusConflict, detail)
+}
+
+func TooManyRequests(detail string) *HTTPError {
+	return NewHTTPError(http.StatusTooManyRequests, detail)
+}
+
+func (e *HTTPError) Error() string {
+	detail := e.Detail
+	if detail == "" {
+		detail = http.StatusText(e.Status)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, detail, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Status, detail)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// Problem is a problem details object (RFC 9457), the body of error responses
+// to API clients.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. It is omitted for
+	// problems that are described by their status alone.
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors holds the failed fields of a ValidationErrors.
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+// ProblemFor returns the problem describing err:
+//
+//   - an HTTPError has its status, or 500 if it has none, and its detail
+//   - ValidationErrors are a 422 listing the invalid fields
+//   - a BindError is a 400 naming the field and source that could not be
+//     bound, but not why
+//   - an http.MaxBytesError is a 413
+//   - anything else is a 500 that reveals nothing about err
+func ProblemFor(err error) Problem {
+	var httpErr *HTTPError
+	var validationErrs ValidationErrors
+	var bindErr *BindError
+	var maxBytesErr *http.MaxBytesError
+	problem := Problem{Status: http.StatusInternalServerError}
+	switch {
+	case errors.As(err, &httpErr):
+		if httpErr.Status != 0 {
+			problem.Status = httpErr.Status
+		}
+		problem.Detail = httpErr.Detail
+	case errors.As(err, &validationErrs):
+		problem.Status = http.StatusUnprocessableEntity
+		problem.Detail = "The input failed validation."
+		problem.Errors = validationErrs
+	case errors.As(err, &maxBytesErr):
+		problem.Status = http.StatusRequestEntityTooLarge
+	case errors.As(err, &bindErr):
+		problem.Status = http.StatusBadRequest
+		problem.Detail = bindErr.detail()
+	}
+	problem.Title = http.StatusText(problem.Status)
+	return problem
+}
+
+// ReturnError responds with the problem describing err (see ProblemFor). htmx
+// and other requests that prefer HTML get the ProblemTemplate fragment, other
+// clients get application/problem+json. Server errors, errors wrapping a
+// cause, and BindErrors are logged with the request.
+//
+// Note that htmx does not swap 4xx and 5xx responses by default; allow them in
+// htmx.config.responseHandling (htmx 2) or in an htmx:beforeSwap listener to
+// show the fragment.
+func ReturnError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := ProblemFor(err)
+	var httpErr *HTTPError
+	var bindErr *BindError
+	if problem.Status >= http.StatusInternalServerError || (errors.As(err, &httpErr) && httpErr.Err != nil) || errors.As(err, &bindErr) {
+		log.Printf("%d Error: %s %s\n\t%v\n", problem.Status, r.Method, r.URL.Path, err)
+	}
+	problem.Instance = r.URL.Path
+	if wantsHTML(r) {
+		writeProblemHTML(w, problem)
+		return
+	}
+	writeProblemJSON(w, problem)
+}
+
+func writeProblemJSON(w http.ResponseWriter, problem Problem) {
+	buf := internal.GetBuffer()
+	defer internal.PutBuffer(buf)
+	err := json.NewEncoder(buf).Encode(problem)
+	if err != nil {
+		log.Printf("Error encoding problem\n\t%v\n", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	_ = internal.WriteBuffer(w, problem.Status, problemContentType, buf)
+}
+
+func writeProblemHTML(w http.ResponseWriter, problem Problem) {
+	var t internal.Executor = defaultProblemTemplate
+	// the cache keeps the partials from being cloned on every error
+	cached, err := internal.APITemplates.String(fmt.Sprintf("{{template %q .}}", ProblemTemplate))
+	if err == nil && cached.Lookup(ProblemTemplate) != nil {
+		t = cached
+	}
+	err = internal.RenderTemplate(w, problem.Status, htmlContentType, t, problem)
+	if err != nil {
+		log.Printf("Error rendering problem\n\t%v\n", err)
+		http.Error(w, problem.Title, problem.Status)
+	}
+}
+
+// ErrorHandlerFunc is a handler that returns an error instead of writing an
+// error response itself. A non-nil error is answered with ReturnError, so the
+// handler can return an HTTPError for a specific status:
+//
+//	gomx.RegisterOnPath("/items/{id}", http.MethodDelete, gomx.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+//		if !data.ItemExists(r.PathValue("id")) {
+//			return gomx.NotFound("There is no such item.")
+//		}
+//		...
+//	}))
+//
+// The handler must not write anything before returning an error.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (handler ErrorHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := handler(w, r)
+	if err != nil {
+		ReturnError(w, r, err)
+	}
+}
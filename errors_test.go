@@ -0,0 +1,76 @@
+package gomx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReturnError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		detail string
+	}{
+		{NotFound("No such item."), http.StatusNotFound, "No such item."},
+		{fmt.Errorf("deleting: %w", Conflict("")), http.StatusConflict, ""},
+		{WrapHTTPError(http.StatusServiceUnavailable, errors.New("db password rejected")), http.StatusServiceUnavailable, ""},
+		{ValidationErrors{"email": "is required"}, http.StatusUnprocessableEntity, "The input failed validation."},
+		{&BindError{Field: "ID", Source: "path", Err: errors.New("strconv.Atoi: parsing \"x\": invalid syntax")}, http.StatusBadRequest, "The path value for ID is invalid."},
+		{&BindError{Source: "json", Err: errors.New("json: cannot unmarshal string into Go struct field secretItem.count of type int")}, http.StatusBadRequest, "The json body is invalid."},
+		{&HTTPError{Detail: "Something broke."}, http.StatusInternalServerError, "Something broke."},
+		{errors.New("secret internals"), http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ReturnError(w, httptest.NewRequest(http.MethodGet, "/items/1", nil), test.err)
+		if w.Code != test.status {
+			t.Errorf("%v: status = %d, want %d", test.err, w.Code, test.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+			t.Errorf("%v: Content-Type = %s", test.err, ct)
+		}
+		if strings.Contains(w.Body.String(), "secret") || strings.Contains(w.Body.String(), "password") || strings.Contains(w.Body.String(), "strconv") {
+			t.Errorf("%v: response leaks the cause: %s", test.err, w.Body.String())
+		}
+		var problem Problem
+		err := json.Unmarshal(w.Body.Bytes(), &problem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problem.Status != test.status || problem.Detail != test.detail || problem.Title != http.StatusText(test.status) || problem.Instance != "/items/1" {
+			t.Errorf("%v: problem = %+v", test.err, problem)
+		}
+	}
+}
+
+func TestReturnErrorHTML(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/signup", nil)
+	r.Header.Set("HX-Request", "true")
+	ReturnError(w, r, ValidationErrors{"email": "must be a valid email address"})
+	want := `<div class="gomx-problem" role="alert"><strong>Unprocessable Entity</strong> The input failed validation.<ul><li>email must be a valid email address</li></ul></div>`
+	if w.Code != http.StatusUnprocessableEntity || w.Body.String() != want {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReturnErrorProblemPartial(t *testing.T) {
+	newTestRouter(t, nil, map[string]string{
+		"routes/_/problem.gohtml": `{{define "problem"}}<p>{{.Status}} {{.Detail}}</p>{{end}}`,
+	})
+	// the partial replaces the default fragment, every time
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/items/4", nil)
+		r.Header.Set("HX-Request", "true")
+		ReturnError(w, r, NotFound("No such item."))
+		if w.Code != http.StatusNotFound || w.Body.String() != "<p>404 No such item.</p>" {
+			t.Errorf("got %d %s", w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package gomx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gomxapp/gomx/htmx"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Categories of flash messages. Any other string can be used as well.
+const (
+	FlashInfo    = "info"
+	FlashSuccess = "success"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// flashKey is the session key flash messages are kept under.
+const flashKey = "gomx.flashes"
+
+// FlashCookieName is the cookie flash messages are kept in for requests
+// without a session.
+var FlashCookieName = "gomx_flash"
+
+// FlashEvent, if set, is the name of the HX-Trigger event that flash messages
+// added during htmx requests are sent as, instead of being kept for the next
+// page. The event detail holds the messages:
+//
+//	document.body.addEventListener("flash", (event) => {
+//		for (const flash of event.detail.messages) showToast(flash.category, flash.message)
+//	})
+//
+// Requests that redirect with HX-Redirect, HX-Location, or HX-Refresh still
+// keep their messages for the next page, so set those headers before adding
+// messages.
+var FlashEvent = ""
+
+// Flash is a message shown once, on the next page the client loads.
+type Flash struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// flashEventDetail is the detail of FlashEvent.
+type flashEventDetail struct {
+	Messages []Flash `json:"messages"`
+}
+
+// AddFlash adds a message to show on the next page, e.g. after a form post
+// that redirects. Messages are kept in the session if the request has one
+// (see Sessions), and in a cookie otherwise. Either way, it must be called
+// before the response is written.
+//
+//	gomx.AddFlash(w, r, gomx.FlashSuccess, "Saved!")
+//	htmx.Redirect(w, "/items")
+func AddFlash(w http.ResponseWriter, r *http.Request, category string, message string) error {
+	flash := Flash{Category: category, Message: message}
+	header := w.Header()
+	if FlashEvent != "" && htmx.IsRequest(r) &&
+		header.Get(htmx.HeaderRedirect) == "" && header.Get(htmx.HeaderLocation) == "" && header.Get(htmx.HeaderRefresh) == "" {
+		return triggerFlash(w, flash)
+	}
+	if session := SessionOf(r); session != nil {
+		flashes, _ := SessionValue[[]Flash](session, flashKey)
+		return session.Set(flashKey, append(flashes, flash))
+	}
+	flashes := flashCookie(r, header)
+	setFlashCookie(r, header, append(flashes, flash))
+	return nil
+}
+
+// triggerFlash adds flash to the messages of the FlashEvent trigger.
+func triggerFlash(w http.ResponseWriter, flash Flash) error {
+	var detail flashEventDetail
+	current := w.Header().Get(htmx.HeaderTrigger)
+	if strings.HasPrefix(strings.TrimSpace(current), "{") {
+		var events map[string]json.RawMessage
+		if json.Unmarshal([]byte(current), &events) == nil && events[FlashEvent] != nil {
+			_ = json.Unmarshal(events[FlashEvent], &detail)
+		}
+	}
+	detail.Messages = append(detail.Messages, flash)
+	return htmx.Trigger(w, FlashEvent, detail)
+}
+
+// Flashes returns the messages added for the client and removes them, so they
+// are only shown once. Like AddFlash, it must be called before the response is
+// written. Templates use the flashes function instead:
+//
+//	{{range flashes .}}<p class="flash-{{.Category}}">{{.Message}}</p>{{end}}
+//
+// On streamed pages, the response is written at the first flush, so flashes
+// must come before it, e.g. near the top of the page. Once a session has been
+// saved, Flashes returns nil and leaves the messages for the next page.
+func Flashes(w http.ResponseWriter, r *http.Request) []Flash {
+	return consumeFlashes(r, w.Header())
+}
+
+// consumeFlashes returns and removes the messages of r. header is the header of
+// the response, used to delete the flash cookie. If it is nil, the cookie is
+// left as it is.
+func consumeFlashes(r *http.Request, header http.Header) []Flash {
+	if session := SessionOf(r); session != nil {
+		// the session would not be saved again, and the messages would be
+		// shown twice
+		if session.isCommitted() {
+			log.Printf("Error reading flash messages of %s\n\tthe session was saved before they were read\n", r.URL.Path)
+			return nil
+		}
+		flashes, ok := SessionValue[[]Flash](session, flashKey)
+		if ok {
+			session.Delete(flashKey)
+		}
+		return flashes
+	}
+	if header == nil {
+		return flashCookie(r, nil)
+	}
+	flashes := flashCookie(r, header)
+	if len(flashes) > 0 {
+		setFlashCookie(r, header, nil)
+	}
+	return flashes
+}
+
+// flashCookie returns the messages in the flash cookie, taking the cookie set
+// on the response, if any, over the one of the request.
+func flashCookie(r *http.Request, header http.Header) []Flash {
+	value, found := "", false
+	response := http.Response{Header: http.Header{"Set-Cookie": header.Values("Set-Cookie")}}
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == FlashCookieName {
+			value, found = cookie.Value, true
+		}
+	}
+	if !found {
+		cookie, err := r.Cookie(FlashCookieName)
+		if err != nil {
+			return nil
+		}
+		value = cookie.Value
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	var flashes []Flash
+	_ = json.Unmarshal(data, &flashes)
+	return flashes
+}
+
+// setFlashCookie replaces the flash cookie set on the response with one holding
+// flashes, or one deleting the cookie if there are none.
+func setFlashCookie(r *http.Request, header http.Header, flashes []Flash) {
+	cookie := &http.Cookie{
+		Name:     FlashCookieName,
+		Path:     "/",
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	for len(flashes) > 0 {
+		data, err := json.Marshal(flashes)
+		if err != nil {
+			log.Printf("Error encoding flash messages\n\t%v\n", err)
+			return
+		}
+		cookie.Value = base64.RawURLEncoding.EncodeToString(data)
+		if len(cookie.Value) <= maxCookieSize {
+			break
+		}
+		// drop the oldest messages rather than the whole cookie
+		flashes = flashes[1:]
+	}
+	if len(flashes) == 0 {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	cookies := header.Values("Set-Cookie")
+	header.Del("Set-Cookie")
+	for _, c := range cookies {
+		if !strings.HasPrefix(c, FlashCookieName+"=") {
+			header.Add("Set-Cookie", c)
+		}
+	}
+	if len(flashes) == 0 && !hasCookie(r, FlashCookieName) {
+		return
+	}
+	header.Add("Set-Cookie", cookie.String())
+}
+
+func hasCookie(r *http.Request, name string) bool {
+	_, err := r.Cookie(name)
+	return err == nil
+}
+
+// templateFlashes is the flashes template function. It returns and removes the
+// messages of the request behind data (see requestOf).
+func templateFlashes(data any) []Flash {
+	r := requestOf(data)
+	if r == nil {
+		return nil
+	}
+	header, _ := r.Context().Value(responseHeaderContextKey).(http.Header)
+	return consumeFlashes(r, header)
+}
@@ -0,0 +1,130 @@
+package gomx
+
+import (
+	"encoding/json"
+	"github.com/gomxapp/gomx/htmx"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// flashHandler adds a flash on POST and returns the flashes as JSON otherwise.
+func flashHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if r.URL.Query().Has("redirect") {
+				htmx.Redirect(w, "/")
+			}
+			for _, message := range []string{"Saved!", "Sent!"} {
+				err := AddFlash(w, r, FlashSuccess, message)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Flashes(w, r))
+	})
+}
+
+func TestFlashes(t *testing.T) {
+	want := `[{"category":"success","message":"Saved!"},{"category":"success","message":"Sent!"}]` + "\n"
+	handlers := map[string]http.Handler{
+		"cookie":  flashHandler(t),
+		"session": Sessions(SessionOptions{Keys: [][]byte{testSessionKey}})(flashHandler(t)),
+	}
+	for name, handler := range handlers {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("%s: cookies = %v", name, cookies)
+		}
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookies[0])
+		handler.ServeHTTP(w, r)
+		if w.Body.String() != want {
+			t.Errorf("%s: flashes = %s", name, w.Body.String())
+		}
+		cookies = w.Result().Cookies()
+		if len(cookies) != 1 || (name == "cookie" && cookies[0].MaxAge != -1) {
+			t.Fatalf("%s: the flashes were not consumed: %v", name, cookies)
+		}
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookies[0])
+		handler.ServeHTTP(w, r)
+		if w.Body.String() != "null\n" {
+			t.Errorf("%s: flashes were shown twice: %s", name, w.Body.String())
+		}
+	}
+}
+
+func TestFlashEvent(t *testing.T) {
+	FlashEvent = "flash"
+	defer func() { FlashEvent = "" }()
+	handler := flashHandler(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(htmx.HeaderRequest, "true")
+	handler.ServeHTTP(w, r)
+	var events map[string]flashEventDetail
+	err := json.Unmarshal([]byte(w.Header().Get(htmx.HeaderTrigger)), &events)
+	want := []Flash{{FlashSuccess, "Saved!"}, {FlashSuccess, "Sent!"}}
+	if err != nil || !reflect.DeepEqual(events["flash"].Messages, want) {
+		t.Errorf("HX-Trigger = %s", w.Header().Get(htmx.HeaderTrigger))
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("cookies = %v", w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/?redirect", nil)
+	r.Header.Set(htmx.HeaderRequest, "true")
+	handler.ServeHTTP(w, r)
+	if w.Header().Get(htmx.HeaderTrigger) != "" || len(w.Result().Cookies()) != 1 {
+		t.Errorf("redirects should keep the flashes for the next page: %v", w.Header())
+	}
+}
+
+func TestFlashesStreamed(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterOnPath("/add", http.MethodPost, flashHandler(t))
+	stream := "---\nstream: true\n---\n"
+	router := newTestRouter(t, registry, map[string]string{
+		"routes/early/early.gohtml": stream + `{{define "content"}}{{range flashes .}}{{.Message}} {{end}}{{end}}`,
+		"routes/late/late.gohtml":   stream + `{{define "content"}}` + strings.Repeat("-", 5000) + `{{range flashes .}}{{.Message}} {{end}}{{end}}`,
+	})
+	router.Use(Sessions(SessionOptions{Keys: [][]byte{testSessionKey}}))
+
+	var cookie *http.Cookie
+	request := func(method string, target string) string {
+		r := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if cookies := w.Result().Cookies(); len(cookies) > 0 {
+			cookie = cookies[0]
+		}
+		return strings.Trim(w.Body.String(), "-")
+	}
+	request(http.MethodPost, "/add")
+	// after the first flush, the session is saved and the messages are kept
+	if body := request(http.MethodGet, "/late"); body != "" {
+		t.Errorf("late: %s", body)
+	}
+	if body := request(http.MethodGet, "/early"); body != "Saved! Sent! " {
+		t.Errorf("early: %s", body)
+	}
+	if body := request(http.MethodGet, "/early"); body != "" {
+		t.Errorf("flashes were shown twice: %s", body)
+	}
+}
@@ -0,0 +1,145 @@
+package gomx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gomxapp/gomx/internal"
+	"html"
+	"net/http"
+)
+
+// Fragment is a template rendered as part of a response with ReturnFragments.
+type Fragment struct {
+	// Files are parsed along with the shared partials, like with
+	// ReturnGoHTMLFromFiles. If there are no files, Name is the name of a
+	// shared partial, i.e. a template defined in the reserved directory or in
+	// a directory of the routes starting with '_'.
+	Files []string
+	// Name is the template to execute. With Files, an empty name executes the
+	// first file.
+	Name string
+	Data any
+	// Swap is the hx-swap-oob value of an out-of-band fragment, e.g.
+	// "beforeend:#items". If it is empty, "true" is used, which swaps the
+	// element with the same id.
+	Swap string
+}
+
+func (fragment *Fragment) template() (internal.Executor, error) {
+	if len(fragment.Files) > 0 {
+		return internal.APITemplates.Files(apiFilePaths(fragment.Files), fragment.Name)
+	}
+	if fragment.Name == "" {
+		return nil, errors.New("fragment has neither files nor the name of a shared partial")
+	}
+	return internal.APITemplates.String(fmt.Sprintf("{{template %q .}}", fragment.Name))
+}
+
+// ReturnFragments renders primary, which htmx swaps into the request's target,
+// followed by out-of-band fragments, which htmx swaps into other parts of the
+// page. The hx-swap-oob attribute is added to the first element of each
+// out-of-band fragment, unless that element already has one. For example, to
+// add an item to a list and update the cart count:
+//
+//	err := gomx.ReturnFragments(w,
+//		gomx.Fragment{Files: []string{"item.gohtml"}, Data: item},
+//		gomx.Fragment{Name: "cart-count", Data: cart.Count()},
+//	)
+//
+// where the cart-count partial renders an element with the id of the count it
+// replaces. The fragments are rendered into one buffer, so nothing is written
+// if an error is returned.
+func ReturnFragments(w http.ResponseWriter, primary Fragment, oob ...Fragment) error {
+	buf := internal.GetBuffer()
+	defer internal.PutBuffer(buf)
+	err := primary.render(buf)
+	if err != nil {
+		return err
+	}
+	fragmentBuf := internal.GetBuffer()
+	defer internal.PutBuffer(fragmentBuf)
+	for i := range oob {
+		fragmentBuf.Reset()
+		err = oob[i].render(fragmentBuf)
+		if err != nil {
+			return err
+		}
+		swap := oob[i].Swap
+		if swap == "" {
+			swap = "true"
+		}
+		err = writeOOB(buf, fragmentBuf.Bytes(), swap)
+		if err != nil {
+			return fmt.Errorf("out-of-band fragment %d: %w", i, err)
+		}
+	}
+	return internal.WriteBuffer(w, 0, htmlContentType, buf)
+}
+
+func (fragment *Fragment) render(buf *bytes.Buffer) error {
+	t, err := fragment.template()
+	if err != nil {
+		return err
+	}
+	return t.Execute(buf, fragment.Data)
+}
+
+// writeOOB writes fragment to buf with an hx-swap-oob attribute added to its
+// first element.
+func writeOOB(buf *bytes.Buffer, fragment []byte, swap string) error {
+	start, nameEnd, tagEnd := firstElement(fragment)
+	if start == -1 {
+		return errors.New("no element to add hx-swap-oob to")
+	}
+	if bytes.Contains(bytes.ToLower(fragment[nameEnd:tagEnd]), []byte("hx-swap-oob")) {
+		buf.Write(fragment)
+		return nil
+	}
+	buf.Write(fragment[:nameEnd])
+	buf.WriteString(` hx-swap-oob="`)
+	buf.WriteString(html.EscapeString(swap))
+	buf.WriteByte('"')
+	buf.Write(fragment[nameEnd:])
+	return nil
+}
+
+// firstElement finds the start tag of the first element in fragment, skipping
+// comments and doctypes. It returns the index of its '<', the end of its name,
+// and the index of its closing '>', or -1 for all if there is none.
+func firstElement(fragment []byte) (int, int, int) {
+	for i := 0; i < len(fragment); i++ {
+		if fragment[i] != '<' || i+1 == len(fragment) {
+			continue
+		}
+		if bytes.HasPrefix(fragment[i:], []byte("<!--")) {
+			end := bytes.Index(fragment[i:], []byte("-->"))
+			if end == -1 {
+				break
+			}
+			i += end + 2
+			continue
+		}
+		if !isASCIILetter(fragment[i+1]) {
+			continue
+		}
+		nameEnd := i + 1
+		for nameEnd < len(fragment) && !isTagNameEnd(fragment[nameEnd]) {
+			nameEnd++
+		}
+		tagEnd := bytes.IndexByte(fragment[nameEnd:], '>')
+		if tagEnd == -1 {
+			break
+		}
+		return i, nameEnd, nameEnd + tagEnd
+	}
+	return -1, -1, -1
+}
+
+func isASCIILetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isTagNameEnd(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '/' || c == '>'
+}
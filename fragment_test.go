@@ -0,0 +1,33 @@
+package gomx
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteOOB(t *testing.T) {
+	tests := []struct {
+		fragment string
+		swap     string
+		want     string
+	}{
+		{`<span id="count">3</span>`, "true", `<span hx-swap-oob="true" id="count">3</span>`},
+		{"\n  <!-- <b> --><li>x</li>", "beforeend:#items", "\n  <!-- <b> --><li hx-swap-oob=\"beforeend:#items\">x</li>"},
+		{`<br/>`, `a"b`, `<br hx-swap-oob="a&#34;b"/>`},
+		{`<div hx-swap-oob="outerHTML" id="a"></div>`, "true", `<div hx-swap-oob="outerHTML" id="a"></div>`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := writeOOB(&buf, []byte(test.fragment), test.swap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("got %s, want %s", buf.String(), test.want)
+		}
+	}
+	err := writeOOB(&bytes.Buffer{}, []byte("just text < 3"), "true")
+	if err == nil {
+		t.Error("expected an error for a fragment without elements")
+	}
+}
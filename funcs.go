@@ -0,0 +1,50 @@
+package gomx
+
+import (
+	"context"
+	"github.com/gomxapp/gomx/internal"
+	"html/template"
+	"net/http"
+)
+
+func init() {
+	internal.AddTemplateFuncs(template.FuncMap{
+		"session":     templateSession,
+		"csrfToken":   templateCSRFToken,
+		"csrfField":   templateCSRFField,
+		"csrfHeaders": templateCSRFHeaders,
+		"flashes":     templateFlashes,
+		"user":        templateUser,
+	})
+}
+
+// requestOf returns the request behind the data of a template: page data, a
+// request, a context from a typed handler, or anything with a Request method,
+// like SSEStream and WebSocketConn.
+func requestOf(data any) *http.Request {
+	switch data := data.(type) {
+	case *http.Request:
+		return data
+	case internal.PageData:
+		return data.Request
+	case *internal.PageData:
+		return data.Request
+	case interface{ Request() *http.Request }:
+		return data.Request()
+	case context.Context:
+		return RequestFromContext(data)
+	}
+	return nil
+}
+
+// templateSession is the session template function. It returns the session of
+// the request behind data (see requestOf), or nil:
+//
+//	{{with session .}}Hello {{.Get "name"}}{{end}}
+func templateSession(data any) *Session {
+	r := requestOf(data)
+	if r == nil {
+		return nil
+	}
+	return SessionOf(r)
+}
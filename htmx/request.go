@@ -0,0 +1,85 @@
+// Package htmx provides typed access to the request and response headers used
+// by htmx (https://htmx.org/reference/#headers).
+//
+// Response headers have to be set before the body is written. The gomx Return
+// functions render into a buffer before writing anything, so headers can be set
+// before or after rendering, as long as it is before the Return call:
+//
+//	htmx.Trigger(w, "itemAdded", map[string]any{"id": item.ID})
+//	htmx.PushURL(w, "/items/"+strconv.Itoa(item.ID))
+//	err := gomx.ReturnGoHTMLFromFiles(w, []string{"item.gohtml"}, "", item)
+package htmx
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Request headers sent by htmx.
+const (
+	HeaderRequest               = "HX-Request"
+	HeaderBoosted               = "HX-Boosted"
+	HeaderCurrentURL            = "HX-Current-URL"
+	HeaderHistoryRestoreRequest = "HX-History-Restore-Request"
+	HeaderPrompt                = "HX-Prompt"
+	HeaderTarget                = "HX-Target"
+	HeaderTriggerName           = "HX-Trigger-Name"
+	// HeaderTrigger is sent with the id of the element that triggered the
+	// request, and is also a response header for triggering events.
+	HeaderTrigger = "HX-Trigger"
+)
+
+// IsRequest returns whether r was sent by htmx.
+func IsRequest(r *http.Request) bool {
+	return r.Header.Get(HeaderRequest) == "true"
+}
+
+// IsBoosted returns whether r was sent by an element using hx-boost.
+func IsBoosted(r *http.Request) bool {
+	return r.Header.Get(HeaderBoosted) == "true"
+}
+
+// IsHistoryRestoreRequest returns whether r asks for a full page to restore
+// history after a cache miss.
+func IsHistoryRestoreRequest(r *http.Request) bool {
+	return r.Header.Get(HeaderHistoryRestoreRequest) == "true"
+}
+
+// IsPartial returns whether r was sent by htmx to swap part of a page, and
+// should therefore be answered with a fragment rather than a full page. This
+// is false for boosted and history restore requests.
+func IsPartial(r *http.Request) bool {
+	return IsRequest(r) && !IsBoosted(r) && !IsHistoryRestoreRequest(r)
+}
+
+// CurrentURL returns the URL of the browser when the request was sent, or nil
+// if it is missing or invalid.
+func CurrentURL(r *http.Request) *url.URL {
+	u, err := url.Parse(r.Header.Get(HeaderCurrentURL))
+	if err != nil || u.String() == "" {
+		return nil
+	}
+	return u
+}
+
+// Prompt returns the user's response to an hx-prompt.
+func Prompt(r *http.Request) string {
+	return r.Header.Get(HeaderPrompt)
+}
+
+// Target returns the id of the target element, if it has one.
+func Target(r *http.Request) string {
+	return r.Header.Get(HeaderTarget)
+}
+
+// TriggerID returns the id of the element that triggered the request, if it
+// has one.
+func TriggerID(r *http.Request) string {
+	return r.Header.Get(HeaderTrigger)
+}
+
+// TriggerName returns the name of the element that triggered the request, if
+// it has one.
+func TriggerName(r *http.Request) string {
+	return r.Header.Get(HeaderTriggerName)
+}
@@ -0,0 +1,184 @@
+package htmx
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Response headers understood by htmx. HeaderTrigger is declared with the
+// request headers.
+const (
+	HeaderLocation           = "HX-Location"
+	HeaderPushURL            = "HX-Push-Url"
+	HeaderRedirect           = "HX-Redirect"
+	HeaderRefresh            = "HX-Refresh"
+	HeaderReplaceURL         = "HX-Replace-Url"
+	HeaderReswap             = "HX-Reswap"
+	HeaderRetarget           = "HX-Retarget"
+	HeaderReselect           = "HX-Reselect"
+	HeaderTriggerAfterSettle = "HX-Trigger-After-Settle"
+	HeaderTriggerAfterSwap   = "HX-Trigger-After-Swap"
+)
+
+// Values of hx-swap and HX-Reswap. Modifiers can be appended after a space,
+// e.g. SwapOuterHTML + " swap:200ms".
+const (
+	SwapInnerHTML   = "innerHTML"
+	SwapOuterHTML   = "outerHTML"
+	SwapTextContent = "textContent"
+	SwapBeforeBegin = "beforebegin"
+	SwapAfterBegin  = "afterbegin"
+	SwapBeforeEnd   = "beforeend"
+	SwapAfterEnd    = "afterend"
+	SwapDelete      = "delete"
+	SwapNone        = "none"
+)
+
+// StatusStopPolling is the status code that makes htmx stop polling an
+// element with an every trigger.
+const StatusStopPolling = 286
+
+// Trigger adds an event that htmx triggers on the target element as soon as
+// the response is received. The detail, which may be nil, is encoded as JSON
+// and available as event.detail.
+//
+// Trigger can be called several times to trigger several events. Events
+// without a detail are sent as a comma-separated list; once one has a detail,
+// the header holds a JSON object with all of them.
+func Trigger(w http.ResponseWriter, event string, detail any) error {
+	return addTrigger(w.Header(), HeaderTrigger, event, detail)
+}
+
+// TriggerAfterSettle is like Trigger, but the event is triggered after the
+// settle step.
+func TriggerAfterSettle(w http.ResponseWriter, event string, detail any) error {
+	return addTrigger(w.Header(), HeaderTriggerAfterSettle, event, detail)
+}
+
+// TriggerAfterSwap is like Trigger, but the event is triggered after the swap
+// step.
+func TriggerAfterSwap(w http.ResponseWriter, event string, detail any) error {
+	return addTrigger(w.Header(), HeaderTriggerAfterSwap, event, detail)
+}
+
+func addTrigger(header http.Header, key string, event string, detail any) error {
+	current := strings.TrimSpace(header.Get(key))
+	if detail == nil && !strings.HasPrefix(current, "{") {
+		events := splitEvents(current)
+		for _, e := range events {
+			if e == event {
+				return nil
+			}
+		}
+		header.Set(key, strings.Join(append(events, event), ", "))
+		return nil
+	}
+	events := make(map[string]json.RawMessage)
+	if strings.HasPrefix(current, "{") {
+		err := json.Unmarshal([]byte(current), &events)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, e := range splitEvents(current) {
+			events[e] = json.RawMessage("null")
+		}
+	}
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	events[event] = data
+	// map keys are sorted, so the header does not depend on call order
+	value, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	header.Set(key, string(value))
+	return nil
+}
+
+func splitEvents(value string) []string {
+	var events []string
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Redirect makes htmx do a full page load of url.
+func Redirect(w http.ResponseWriter, url string) {
+	w.Header().Set(HeaderRedirect, url)
+}
+
+// Refresh makes htmx reload the whole page.
+func Refresh(w http.ResponseWriter) {
+	w.Header().Set(HeaderRefresh, "true")
+}
+
+// PushURL pushes url into the browser history. PushURL(w, "false") prevents
+// the element's hx-push-url from pushing a URL.
+func PushURL(w http.ResponseWriter, url string) {
+	w.Header().Set(HeaderPushURL, url)
+}
+
+// ReplaceURL replaces the current URL in the browser history.
+// ReplaceURL(w, "false") prevents the element's hx-replace-url from doing so.
+func ReplaceURL(w http.ResponseWriter, url string) {
+	w.Header().Set(HeaderReplaceURL, url)
+}
+
+// Retarget swaps the response into the elements matching selector instead of
+// the request's target.
+func Retarget(w http.ResponseWriter, selector string) {
+	w.Header().Set(HeaderRetarget, selector)
+}
+
+// Reswap overrides how the response is swapped, e.g. SwapOuterHTML.
+func Reswap(w http.ResponseWriter, swap string) {
+	w.Header().Set(HeaderReswap, swap)
+}
+
+// Reselect chooses which part of the response is swapped in, overriding the
+// element's hx-select.
+func Reselect(w http.ResponseWriter, selector string) {
+	w.Header().Set(HeaderReselect, selector)
+}
+
+// Location describes a client-side redirect done with an AJAX request, like
+// hx-boost does, without a full page load. Only Path is required.
+type Location struct {
+	Path    string            `json:"path"`
+	Source  string            `json:"source,omitempty"`
+	Event   string            `json:"event,omitempty"`
+	Handler string            `json:"handler,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	Swap    string            `json:"swap,omitempty"`
+	Select  string            `json:"select,omitempty"`
+	Values  map[string]any    `json:"values,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// SetLocation sets HX-Location from location. A location with only a path is
+// sent as a plain path.
+func SetLocation(w http.ResponseWriter, location Location) error {
+	if location.pathOnly() {
+		w.Header().Set(HeaderLocation, location.Path)
+		return nil
+	}
+	value, err := json.Marshal(location)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(HeaderLocation, string(value))
+	return nil
+}
+
+func (location *Location) pathOnly() bool {
+	return location.Source == "" && location.Event == "" && location.Handler == "" &&
+		location.Target == "" && location.Swap == "" && location.Select == "" &&
+		len(location.Values) == 0 && len(location.Headers) == 0
+}
@@ -0,0 +1,52 @@
+package htmx
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrigger(t *testing.T) {
+	w := httptest.NewRecorder()
+	steps := []struct {
+		event  string
+		detail any
+		want   string
+	}{
+		{"opened", nil, "opened"},
+		{"saved", nil, "opened, saved"},
+		{"saved", nil, "opened, saved"},
+		{"itemAdded", map[string]int{"id": 3}, `{"itemAdded":{"id":3},"opened":null,"saved":null}`},
+		{"closed", nil, `{"closed":null,"itemAdded":{"id":3},"opened":null,"saved":null}`},
+		{"opened", "again", `{"closed":null,"itemAdded":{"id":3},"opened":"again","saved":null}`},
+	}
+	for _, step := range steps {
+		err := Trigger(w, step.event, step.detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Header().Get(HeaderTrigger); got != step.want {
+			t.Errorf("after %s: got %s, want %s", step.event, got, step.want)
+		}
+	}
+}
+
+func TestSetLocation(t *testing.T) {
+	tests := []struct {
+		location Location
+		want     string
+	}{
+		{Location{Path: "/items"}, "/items"},
+		{Location{Path: "/items", Values: map[string]any{}}, "/items"},
+		{Location{Path: "/items", Target: "#main"}, `{"path":"/items","target":"#main"}`},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		err := SetLocation(w, test.location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Header().Get(HeaderLocation); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var httpMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// APIPathFromFile returns the API path for a Go source file inside the API root
+// directory. The path follows the file's directories relative to root, and
+// underscores in the file name are replaced with slashes. Since braces are not
+// allowed in Go import paths, a directory named like _id_ is a wildcard. A
+// method suffix in the file name, like items.post.go, is returned as method.
+//
+// Example paths:
+//
+// items.go => "/items"
+// items.post.go => "/items", "POST"
+// users/_id_/comments.go => "/users/{id}/comments"
+// users_{id}_comments.go => "/users/{id}/comments"
+func APIPathFromFile(root string, file string) (path string, method string, err error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", "", err
+	}
+	relPath, err := filepath.Rel(absRoot, filepath.FromSlash(file))
+	if err != nil || !filepath.IsAbs(filepath.FromSlash(file)) {
+		return "", "", fmt.Errorf("cannot locate %s relative to the API root %s (was it built with -trimpath?)", file, absRoot)
+	}
+	relPath = filepath.ToSlash(relPath)
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", "", fmt.Errorf("%s is outside of the API root %s (see \"apiRoot\" in gomx.config.json)", file, absRoot)
+	}
+	if !strings.HasSuffix(relPath, ".go") {
+		return "", "", fmt.Errorf("%s is not a Go file", file)
+	}
+	dir, name := pathSplit(strings.TrimSuffix(relPath, ".go"))
+	if base, suffix, found := strings.Cut(name, "."); found {
+		method = strings.ToUpper(suffix)
+		if !slices.Contains(httpMethods, method) {
+			return "", "", fmt.Errorf("%s has an unknown method suffix %q", file, suffix)
+		}
+		name = base
+	}
+	name = strings.ReplaceAll(name, "_", "/")
+	if dir == "" {
+		return "/" + name, method, nil
+	}
+	dirs := strings.Split(dir, "/")
+	for i, d := range dirs {
+		if len(d) > 2 && strings.HasPrefix(d, "_") && strings.HasSuffix(d, "_") {
+			dirs[i] = wildcardPrefix + d[1:len(d)-1] + wildcardSuffix
+		}
+	}
+	return "/" + strings.Join(dirs, "/") + "/" + name, method, nil
+}
+
+// pathSplit splits a slash-separated path into its directory and file name.
+func pathSplit(path string) (string, string) {
+	i := strings.LastIndex(path, "/")
+	if i == -1 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIPathFromFile(t *testing.T) {
+	root, err := filepath.Abs("app/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.ToSlash(root)
+
+	t.Run("test derived paths", func(t *testing.T) {
+		cases := []struct {
+			file   string
+			path   string
+			method string
+		}{
+			{"items.go", "/items", ""},
+			{"items.post.go", "/items", "POST"},
+			{"users/{id}/comments.go", "/users/{id}/comments", ""},
+			{"users/{id}/comments.Delete.go", "/users/{id}/comments", "DELETE"},
+			{"users/_id_/comments.go", "/users/{id}/comments", ""},
+			{"users_{id}_comments.go", "/users/{id}/comments", ""},
+			{"shop/cart_items.put.go", "/shop/cart/items", "PUT"},
+		}
+		for _, c := range cases {
+			path, method, err := APIPathFromFile("app/api", root+"/"+c.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ExpectEqual(t, path, c.path)
+			ExpectEqual(t, method, c.method)
+		}
+	})
+
+	t.Run("test invalid files", func(t *testing.T) {
+		for _, file := range []string{
+			root + "/../routes/items.go",
+			root + "/items.fetch.go",
+			root + "/items.gohtml",
+			"example.com/app/api/items.go",
+		} {
+			_, _, err := APIPathFromFile("app/api", file)
+			ExpectEqual(t, err != nil, true)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomxapp/gomx/config"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImmutableCacheControl is sent with assets requested by their fingerprinted
+// name, which changes whenever their content does.
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// asset is a static file and its fingerprinted name. Names are URL paths
+// without the leading slash, e.g. "static/output.css".
+type asset struct {
+	name    string
+	hashed  string
+	file    string
+	modTime time.Time
+	size    int64
+}
+
+// AssetRegistry holds the assets of a router's static directories.
+type AssetRegistry struct {
+	mu sync.RWMutex
+	// dirs are the static directories in the order they were added.
+	dirs     []string
+	byName   map[string]*asset
+	byHashed map[string]*asset
+}
+
+// DefaultAssets are the assets of DefaultRouter. The asset function of
+// templates that no router parses, like API templates, looks them up here.
+var DefaultAssets = NewAssetRegistry()
+
+func NewAssetRegistry() *AssetRegistry {
+	return &AssetRegistry{
+		byName:   make(map[string]*asset),
+		byHashed: make(map[string]*asset),
+	}
+}
+
+// Fingerprint returns name with the first ten hex digits of the SHA-256 of
+// data before its extension, e.g. "output.2f6e1b0c9a.css".
+func Fingerprint(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:5])
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// ReadAssetManifest reads the manifest at config.AssetManifest, which maps the
+// names of assets to their fingerprinted names. A missing manifest is not an
+// error.
+func ReadAssetManifest() (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(config.AppRootDir, config.AssetManifest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]string
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", config.AssetManifest, err)
+	}
+	return manifest, nil
+}
+
+// AddDir fingerprints the files in dir, a directory under config.AppRootDir
+// that is served at "/dir/". Names in manifest are used instead of hashing the
+// files, except in dev mode. Precompressed .gz files are served in place of
+// the file they compress, so they are not added.
+func (registry *AssetRegistry) AddDir(dir string, manifest map[string]string) error {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	root := filepath.Join(config.AppRootDir, dir)
+	var added []*asset
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".gz") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		a := &asset{name: dir + "/" + filepath.ToSlash(rel), file: file}
+		if hashed, ok := manifest[a.name]; ok && !config.DevMode {
+			a.hashed = hashed
+		} else {
+			err = a.hash()
+			if err != nil {
+				return err
+			}
+		}
+		added = append(added, a)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.dirs = append(registry.dirs, dir)
+	for _, a := range added {
+		registry.add(a)
+	}
+	return nil
+}
+
+// hash reads the file and sets its fingerprinted name.
+func (a *asset) hash() error {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	a.hashed = Fingerprint(a.name, data)
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+	return nil
+}
+
+// isStale returns whether the file has changed since it was hashed.
+func (a *asset) isStale() bool {
+	info, err := os.Stat(a.file)
+	return err == nil && (!info.ModTime().Equal(a.modTime) || info.Size() != a.size)
+}
+
+// add must be called with registry.mu locked.
+func (registry *AssetRegistry) add(a *asset) {
+	if old, ok := registry.byName[a.name]; ok {
+		delete(registry.byHashed, old.hashed)
+	}
+	registry.byName[a.name] = a
+	registry.byHashed[a.hashed] = a
+}
+
+// lookup returns the asset called name, which is either relative to the app
+// root, like "static/output.css", or to one of the static directories, like
+// "output.css". In dev mode, files that were added or changed since they were
+// last hashed are hashed again.
+func (registry *AssetRegistry) lookup(name string) (*asset, error) {
+	registry.mu.RLock()
+	a, ok := registry.byName[name]
+	dirs := registry.dirs
+	for i := 0; !ok && i < len(dirs); i++ {
+		a, ok = registry.byName[dirs[i]+"/"+name]
+	}
+	registry.mu.RUnlock()
+	if !config.DevMode {
+		if !ok {
+			return nil, fmt.Errorf("asset %q is not in a static directory", name)
+		}
+		return a, nil
+	}
+	if ok && !a.isStale() {
+		return a, nil
+	}
+	if !ok {
+		a = registry.find(dirs, name)
+		if a == nil {
+			return nil, fmt.Errorf("asset %q is not in a static directory", name)
+		}
+	}
+	fresh := &asset{name: a.name, file: a.file}
+	err := fresh.hash()
+	if err != nil {
+		return nil, err
+	}
+	registry.mu.Lock()
+	registry.add(fresh)
+	registry.mu.Unlock()
+	return fresh, nil
+}
+
+// find returns an unhashed asset for a file created after its directory was
+// added, or nil if there is no such file.
+func (registry *AssetRegistry) find(dirs []string, name string) *asset {
+	for _, dir := range dirs {
+		candidates := []string{dir + "/" + name}
+		if strings.HasPrefix(name, dir+"/") {
+			candidates = append(candidates, name)
+		}
+		for _, candidate := range candidates {
+			file := filepath.Join(config.AppRootDir, filepath.FromSlash(candidate))
+			info, err := os.Stat(file)
+			if err == nil && info.Mode().IsRegular() {
+				return &asset{name: candidate, file: file}
+			}
+		}
+	}
+	return nil
+}
+
+// URL returns the URL path of the fingerprinted asset called name. It is the
+// "asset" template function:
+//
+//	<link rel="stylesheet" href="{{asset "output.css"}}">
+func (registry *AssetRegistry) URL(name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	a, err := registry.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return "/" + a.hashed, nil
+}
+
+// Resolve returns the name of the asset whose fingerprinted name is hashed,
+// e.g. "static/output.css" for "static/output.2f6e1b0c9a.css".
+func (registry *AssetRegistry) Resolve(hashed string) (string, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	a, ok := registry.byHashed[hashed]
+	if !ok {
+		return "", false
+	}
+	return a.name, true
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"github.com/gomxapp/gomx/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	// the gomx CLI computes the same names
+	ExpectEqual(t, Fingerprint("static/output.css", []byte("body{}")), "static/output.7c98040a54.css")
+	ExpectEqual(t, Fingerprint("static/LICENSE", []byte("body{}")), "static/LICENSE.7c98040a54")
+}
+
+func TestAssets(t *testing.T) {
+	dir := t.TempDir()
+	oldAppRootDir, oldDevMode := config.AppRootDir, config.DevMode
+	t.Cleanup(func() {
+		config.AppRootDir, config.DevMode = oldAppRootDir, oldDevMode
+	})
+	config.AppRootDir = dir
+	config.DevMode = false
+	writeFile := func(name string, content string) {
+		t.Helper()
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(file), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(file, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("assets-test/output.css", "body{}")
+	writeFile("assets-test/img/logo.svg", "<svg></svg>")
+	writeFile("assets-test/output.css.gz", "")
+	assets := NewAssetRegistry()
+	err := assets.AddDir("/assets-test/", map[string]string{"assets-test/img/logo.svg": "assets-test/img/logo.built.svg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"output.css", "/assets-test/output.css", "assets-test/../assets-test/output.css"} {
+		url, err := assets.URL(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ExpectEqual(t, url, "/assets-test/output.7c98040a54.css")
+	}
+	url, err := assets.URL("img/logo.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, url, "/assets-test/img/logo.built.svg")
+	_, err = assets.URL("output.css.gz")
+	if err == nil {
+		t.Error("precompressed files should not be assets")
+	}
+	name, ok := assets.Resolve("assets-test/output.7c98040a54.css")
+	ExpectEqual(t, ok, true)
+	ExpectEqual(t, name, "assets-test/output.css")
+
+	// in dev mode, changed and new files are hashed again
+	config.DevMode = true
+	writeFile("assets-test/output.css", "body{color:red}")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(filepath.Join(dir, "assets-test", "output.css"), future, future)
+	url, err = assets.URL("output.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, url, "/"+Fingerprint("assets-test/output.css", []byte("body{color:red}")))
+	_, ok = assets.Resolve("assets-test/output.7c98040a54.css")
+	ExpectEqual(t, ok, false)
+	writeFile("assets-test/app.js", "")
+	url, err = assets.URL("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, strings.HasPrefix(url, "/assets-test/app."), true)
+}
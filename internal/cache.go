@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+)
+
+type cacheOptionsContextKey struct{}
+
+// CacheOptions is shared through the request context by the router, which
+// answers conditional requests, and the handlers and middleware that decide
+// how the response is cached.
+type CacheOptions struct {
+	// ETag generates an ETag from the body of buffered responses.
+	ETag bool
+	// CacheControl is sent as the Cache-Control header of successful
+	// responses that do not set one.
+	CacheControl string
+}
+
+// WithCacheOptions returns r with options in its context.
+func WithCacheOptions(r *http.Request, options *CacheOptions) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cacheOptionsContextKey{}, options))
+}
+
+// CacheOptionsOf returns the options in r's context, or nil if there are none.
+func CacheOptionsOf(r *http.Request) *CacheOptions {
+	options, _ := r.Context().Value(cacheOptionsContextKey{}).(*CacheOptions)
+	return options
+}
+
+// ETag returns a strong entity tag for body.
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
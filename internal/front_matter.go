@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const frontMatterDelimiter = "---"
+
+// LayoutNone is the front matter layout value for pages that are rendered
+// without the base template.
+const LayoutNone = "none"
+
+// PageMeta is the metadata declared in a page's front matter. It is passed to
+// page templates as PageData.Meta so the base template can render the title
+// and meta tags of the current page.
+//
+// Front matter is a block of "key: value" lines at the very top of a page
+// file, between two "---" lines:
+//
+//	---
+//	title: Items
+//	description: Everything we sell
+//	og:image: /static/items.png
+//	cache-control: public, max-age=60
+//	etag: true
+//	header.X-Frame-Options: DENY
+//	roles: admin
+//	---
+//	{{define "content"}}...{{end}}
+type PageMeta struct {
+	Title       string
+	Description string
+	// OpenGraph holds the "og:" fields without their prefix, e.g. "image".
+	OpenGraph map[string]string
+	// CacheControl is sent as the Cache-Control header.
+	CacheControl string
+	// ETag turns the ETag generated from the rendered page on or off. If nil,
+	// the router's default is used.
+	ETag *bool
+	// Status is the response status code. If zero, the status is 200.
+	Status int
+	// Layout is the base template to render the page with, relative to
+	// config.AppRootDir. LayoutNone renders the page files on their own. If
+	// empty, config.BaseTemplate is used.
+	Layout string
+	// Stream renders the page straight into the response instead of
+	// buffering it. See StreamTemplate. If nil, the page is buffered.
+	Stream *bool
+	// Headers are extra response headers, declared as "header.Name: value".
+	Headers map[string]string
+	// Guard is declared with the "auth" and "roles" keys of a guard file. It
+	// takes precedence over the guard files of the page's directories.
+	Guard *Guard
+}
+
+// merge overrides the fields of meta with the non-zero fields of other.
+func (meta *PageMeta) merge(other PageMeta) {
+	if other.Title != "" {
+		meta.Title = other.Title
+	}
+	if other.Description != "" {
+		meta.Description = other.Description
+	}
+	for k, v := range other.OpenGraph {
+		if meta.OpenGraph == nil {
+			meta.OpenGraph = make(map[string]string)
+		}
+		meta.OpenGraph[k] = v
+	}
+	if other.CacheControl != "" {
+		meta.CacheControl = other.CacheControl
+	}
+	if other.ETag != nil {
+		meta.ETag = other.ETag
+	}
+	if other.Status != 0 {
+		meta.Status = other.Status
+	}
+	if other.Layout != "" {
+		meta.Layout = other.Layout
+	}
+	if other.Stream != nil {
+		meta.Stream = other.Stream
+	}
+	for k, v := range other.Headers {
+		if meta.Headers == nil {
+			meta.Headers = make(map[string]string)
+		}
+		meta.Headers[k] = v
+	}
+	if other.Guard != nil {
+		meta.Guard = other.Guard
+	}
+}
+
+// streams returns whether the page is streamed.
+func (meta *PageMeta) streams() bool {
+	return meta.Stream != nil && *meta.Stream
+}
+
+// applyHeaders sets the headers declared in the front matter on w.
+func (meta *PageMeta) applyHeaders(w http.ResponseWriter) {
+	if meta.CacheControl != "" {
+		w.Header().Set("Cache-Control", meta.CacheControl)
+	}
+	for k, v := range meta.Headers {
+		w.Header().Set(k, v)
+	}
+}
+
+// ParseFrontMatter splits content into its front matter and the template text
+// that follows it. If content has no front matter, it is returned unchanged.
+func ParseFrontMatter(content string) (PageMeta, string, error) {
+	var meta PageMeta
+	firstLine, rest, found := strings.Cut(content, "\n")
+	if !found || strings.TrimSpace(firstLine) != frontMatterDelimiter {
+		return meta, content, nil
+	}
+	for lineNumber := 2; rest != ""; lineNumber++ {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == frontMatterDelimiter {
+			return meta, rest, nil
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			return meta, "", fmt.Errorf("front matter line %d: expected \"key: value\"", lineNumber)
+		}
+		// "og:image: ..." has a colon in its key
+		if key == "og" {
+			var ogKey string
+			ogKey, value, found = strings.Cut(value, ":")
+			if !found {
+				return meta, "", fmt.Errorf("front matter line %d: expected \"og:key: value\"", lineNumber)
+			}
+			key = "og:" + strings.TrimSpace(ogKey)
+		}
+		err := meta.set(strings.TrimSpace(key), strings.TrimSpace(value))
+		if err != nil {
+			return meta, "", fmt.Errorf("front matter line %d: %v", lineNumber, err)
+		}
+	}
+	return meta, "", fmt.Errorf("front matter is missing its closing %q", frontMatterDelimiter)
+}
+
+func (meta *PageMeta) set(key string, value string) error {
+	switch lower := strings.ToLower(key); {
+	case lower == "title":
+		meta.Title = value
+	case lower == "description":
+		meta.Description = value
+	case strings.HasPrefix(lower, "og:"):
+		if meta.OpenGraph == nil {
+			meta.OpenGraph = make(map[string]string)
+		}
+		meta.OpenGraph[strings.TrimPrefix(lower, "og:")] = value
+	case lower == "cache-control":
+		meta.CacheControl = value
+	case lower == "etag":
+		etag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid etag value %q", value)
+		}
+		meta.ETag = &etag
+	case lower == "status":
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 999 {
+			return fmt.Errorf("invalid status %q", value)
+		}
+		meta.Status = status
+	case lower == "layout":
+		meta.Layout = value
+	case lower == "stream":
+		stream, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid stream value %q", value)
+		}
+		meta.Stream = &stream
+	case lower == "auth" || lower == "roles":
+		if meta.Guard == nil {
+			meta.Guard = &Guard{}
+		}
+		return meta.Guard.set(lower, value)
+	case strings.HasPrefix(lower, "header."):
+		if meta.Headers == nil {
+			meta.Headers = make(map[string]string)
+		}
+		meta.Headers[http.CanonicalHeaderKey(key[len("header."):])] = value
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// readPageFile reads a page file and strips its front matter.
+func readPageFile(path string) (PageMeta, string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return PageMeta{}, "", err
+	}
+	meta, text, err := ParseFrontMatter(string(content))
+	if err != nil {
+		return meta, "", fmt.Errorf("%s: %v", path, err)
+	}
+	return meta, text, nil
+}
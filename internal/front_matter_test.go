@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	t.Run("test page with front matter", func(t *testing.T) {
+		content := "---\r\n" +
+			"title: Items: All of them\r\n" +
+			"# comments and blank lines are skipped\r\n" +
+			"\r\n" +
+			"og:image: https://example.com/items.png\r\n" +
+			"cache-control: public, max-age=60\r\n" +
+			"etag: false\r\n" +
+			"status: 202\r\n" +
+			"layout: none\r\n" +
+			"stream: true\r\n" +
+			"header.x-frame-options: DENY\r\n" +
+			"---\r\n" +
+			`{{define "content"}}items{{end}}`
+		meta, text, err := ParseFrontMatter(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ExpectEqual(t, text, `{{define "content"}}items{{end}}`)
+		ExpectEqual(t, meta.Title, "Items: All of them")
+		ExpectEqual(t, meta.OpenGraph["image"], "https://example.com/items.png")
+		ExpectEqual(t, meta.CacheControl, "public, max-age=60")
+		ExpectEqual(t, *meta.ETag, false)
+		ExpectEqual(t, meta.Status, 202)
+		ExpectEqual(t, meta.Layout, LayoutNone)
+		ExpectEqual(t, *meta.Stream, true)
+		ExpectEqual(t, meta.Headers["X-Frame-Options"], "DENY")
+	})
+
+	t.Run("test page without front matter", func(t *testing.T) {
+		content := `{{define "content"}}---{{end}}`
+		meta, text, err := ParseFrontMatter(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ExpectEqual(t, text, content)
+		ExpectEqual(t, meta.Title, "")
+	})
+
+	t.Run("test merging front matter", func(t *testing.T) {
+		parse := func(content string) PageMeta {
+			meta, _, err := ParseFrontMatter(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return meta
+		}
+		meta := parse("---\nstream: true\netag: true\nheader.x-a: 1\n---\n")
+		meta.merge(parse(""))
+		ExpectEqual(t, meta.streams(), true)
+		// a page can turn off what it inherits
+		meta.merge(parse("---\nstream: false\netag: false\nheader.x-b: 2\n---\n"))
+		ExpectEqual(t, meta.streams(), false)
+		ExpectEqual(t, *meta.ETag, false)
+		ExpectEqual(t, meta.Headers["X-A"]+meta.Headers["X-B"], "12")
+	})
+
+	t.Run("test invalid front matter", func(t *testing.T) {
+		for _, content := range []string{
+			"---\ntitle: unterminated\n",
+			"---\nstatus: ok\n---\n",
+			"---\nunknown: key\n---\n",
+			"---\nno colon\n---\n",
+		} {
+			_, _, err := ParseFrontMatter(content)
+			ExpectEqual(t, err != nil, true)
+		}
+	})
+}
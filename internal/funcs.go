@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"html/template"
+)
+
+// templateFuncs are the functions available to every page, partial, and API
+// template. Routers replace asset in the templates they parse with one that
+// looks up their own assets (see assetFuncs).
+var templateFuncs = template.FuncMap{
+	"asset": DefaultAssets.URL,
+}
+
+// assetFuncs returns the template functions that depend on the assets of a
+// router.
+func assetFuncs(assets *AssetRegistry) template.FuncMap {
+	return template.FuncMap{"asset": assets.URL}
+}
+
+// AddTemplateFuncs makes funcs available to every template. It must be called
+// before any template is parsed, e.g. in an init function.
+func AddTemplateFuncs(funcs template.FuncMap) {
+	for name, fn := range funcs {
+		templateFuncs[name] = fn
+	}
+}
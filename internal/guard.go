@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GuardFile is the file in a routes directory that declares who may access the
+// directory and its subdirectories:
+//
+//	# app/routes/admin/_guard
+//	auth: true
+//	roles: admin, editor
+//
+// The closest guard applies, so a subdirectory can lift the guard of its
+// parent with "auth: false".
+const GuardFile = "_guard"
+
+// Guard declares who may access a route.
+type Guard struct {
+	// Dir is the route pattern of the directory a guard file applies to, e.g.
+	// "/admin" or "/items/{id}". It is empty for guards in front matter.
+	Dir string
+	// Auth requires an authenticated user.
+	Auth bool
+	// Roles require the user to have one of them. They imply Auth.
+	Roles []string
+}
+
+func (guard *Guard) set(key string, value string) error {
+	switch strings.ToLower(key) {
+	case "auth":
+		auth, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid auth value %q", value)
+		}
+		guard.Auth = auth
+	case "roles":
+		guard.Roles = nil
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				guard.Roles = append(guard.Roles, role)
+			}
+		}
+		if len(guard.Roles) > 0 {
+			guard.Auth = true
+		}
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// ParseGuard parses the "key: value" lines of a guard file.
+func ParseGuard(content string) (Guard, error) {
+	var guard Guard
+	for lineNumber, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return guard, fmt.Errorf("line %d: expected \"key: value\"", lineNumber+1)
+		}
+		err := guard.set(strings.TrimSpace(key), strings.TrimSpace(value))
+		if err != nil {
+			return guard, fmt.Errorf("line %d: %v", lineNumber+1, err)
+		}
+	}
+	return guard, nil
+}
+
+// readGuardFile reads the guard file of dirPath, if there is one.
+func readGuardFile(dirPath string) (*Guard, error) {
+	path := filepath.Join(dirPath, GuardFile)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	guard, err := ParseGuard(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &guard, nil
+}
+
+// dirParts returns the path segments of the guard's directory.
+func (guard *Guard) dirParts() []string {
+	dir := strings.Trim(guard.Dir, "/")
+	if dir == "" {
+		return nil
+	}
+	return strings.Split(dir, "/")
+}
+
+// Contains returns whether escapedPath, as returned by URL.EscapedPath, is the
+// guard's directory or lies within it. Wildcards in Dir match any path segment,
+// and the other segments are compared unescaped.
+func (guard *Guard) Contains(escapedPath string) bool {
+	dirParts := guard.dirParts()
+	if len(dirParts) == 0 {
+		return true
+	}
+	pathParts := strings.Split(strings.Trim(escapedPath, "/"), "/")
+	if len(pathParts) < len(dirParts) {
+		return false
+	}
+	for i, part := range dirParts {
+		if pathPartIsWildcard(part) {
+			continue
+		}
+		pathPart, err := url.PathUnescape(pathParts[i])
+		if err != nil || part != pathPart {
+			return false
+		}
+	}
+	return true
+}
+
+// MostSpecificGuard returns the most specific of the guards containing
+// escapedPath, or nil. Like the route tree, it prefers static segments to
+// wildcards from the root down, and a subdirectory to its parent.
+func MostSpecificGuard(guards []Guard, escapedPath string) *Guard {
+	var best *Guard
+	for i := range guards {
+		if guards[i].Contains(escapedPath) && (best == nil || guards[i].moreSpecific(best)) {
+			best = &guards[i]
+		}
+	}
+	return best
+}
+
+func (guard *Guard) moreSpecific(other *Guard) bool {
+	parts, otherParts := guard.dirParts(), other.dirParts()
+	for i := 0; i < len(parts) && i < len(otherParts); i++ {
+		wild, otherWild := pathPartIsWildcard(parts[i]), pathPartIsWildcard(otherParts[i])
+		if wild != otherWild {
+			return otherWild
+		}
+	}
+	return len(parts) > len(otherParts)
+}
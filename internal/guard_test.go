@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestParseGuard(t *testing.T) {
+	guard, err := ParseGuard("# admins only\nroles: admin, editor\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, guard.Auth, true)
+	ExpectEqual(t, len(guard.Roles), 2)
+	ExpectEqual(t, guard.Roles[1], "editor")
+
+	guard, err = ParseGuard("auth: false")
+	if err != nil || guard.Auth {
+		t.Errorf("guard = %v, %v", guard, err)
+	}
+	_, err = ParseGuard("owner: me")
+	if err == nil {
+		t.Error("unknown keys should be rejected")
+	}
+
+	meta, _, err := ParseFrontMatter("---\nauth: true\n---\n")
+	if err != nil || meta.Guard == nil || !meta.Guard.Auth {
+		t.Errorf("front matter guard = %v, %v", meta.Guard, err)
+	}
+}
+
+func TestGuardContains(t *testing.T) {
+	guard := Guard{Dir: "/items/{id}"}
+	for path, want := range map[string]bool{
+		"/items/4":       true,
+		"/items/4/edit":  true,
+		"/items":         false,
+		"/itemsx/4":      false,
+		"/other/items/4": false,
+		"/items/a%2Fb":   true,
+	} {
+		if guard.Contains(path) != want {
+			t.Errorf("%s: contains = %t", path, !want)
+		}
+	}
+	// an escaped slash stays within its segment
+	admin := Guard{Dir: "/{w}/admin"}
+	ExpectEqual(t, admin.Contains("/x%2Fy/admin"), true)
+	ExpectEqual(t, admin.Contains("/x%2Fadmin"), false)
+	spaced := Guard{Dir: "/my files"}
+	ExpectEqual(t, spaced.Contains("/my%20files/a"), true)
+	root := Guard{Dir: "/"}
+	ExpectEqual(t, root.Contains("/anything"), true)
+}
@@ -1,24 +1,66 @@
 package internal
 
 import (
-	"html/template"
 	"log"
 	"net/http"
 )
 
+const htmlContentType = "text/html; charset=utf-8"
+
 type PageData struct {
 	Arg any
+	// Meta is the metadata from the page's front matter.
+	Meta PageMeta
+	// Request is the request being served, for template functions like
+	// session that take the page data.
+	Request *http.Request
 }
 
 type TemplateHandler struct {
-	template *template.Template
+	template Executor
 	data     PageData
+	// contentType is sent with the page. If empty, the page is HTML.
+	contentType string
+	// errorHandler serves the error page when the template fails to execute.
+	errorHandler http.Handler
+	// stream executes the template straight into the response, flushing as it
+	// goes, instead of buffering the whole page.
+	stream bool
 }
 
 func (tph *TemplateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := tph.template.Execute(w, tph.data)
+	data := tph.data
+	data.Request = r
+	meta := &data.Meta
+	if options := CacheOptionsOf(r); options != nil && meta.ETag != nil {
+		options.ETag = *meta.ETag
+	}
+	contentType := tph.contentType
+	if contentType == "" {
+		contentType = htmlContentType
+	}
+	if tph.stream {
+		meta.applyHeaders(w)
+		err := StreamTemplate(w, meta.Status, contentType, tph.template, data)
+		if err != nil {
+			log.Printf("Error streaming page %s\n\t%v\n", r.URL.Path, err)
+		}
+		return
+	}
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	err := tph.template.Execute(buf, data)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Error executing template", 500)
+		log.Printf("Error executing page template %s\n\t%v\n", r.URL.Path, err)
+		if tph.errorHandler != nil {
+			tph.errorHandler.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
 	}
+	// headers are only applied once the page has rendered, so an error page
+	// does not inherit them
+	meta.applyHeaders(w)
+	_ = WriteBuffer(w, meta.Status, contentType, buf)
 }
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateHandlerError(t *testing.T) {
+	layout := template.Must(template.New("index.gohtml").Parse(`<main>{{block "content" .}}{{end}}</main>`))
+	page := template.Must(template.Must(layout.Clone()).Parse(`{{define "content"}}rendered{{.Arg.Missing}}{{end}}`))
+	errorFile := filepath.Join(t.TempDir(), "500.gohtml")
+	err := os.WriteFile(errorFile, []byte("---\nheader.X-Error: yes\n---\n{{define \"content\"}}Something went wrong{{end}}"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := PageMeta{Status: http.StatusAccepted, CacheControl: "public, max-age=60", Headers: map[string]string{"X-Page": "yes"}}
+	handler := &TemplateHandler{
+		template:     page,
+		data:         PageData{Meta: meta},
+		errorHandler: createErrorPageHandler(layout, errorFile, http.StatusInternalServerError, nil),
+	}
+
+	// nothing of the failed page is written, not even its headers
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ExpectEqual(t, w.Code, http.StatusInternalServerError)
+	ExpectEqual(t, w.Body.String(), "<main>Something went wrong</main>")
+	ExpectEqual(t, w.Header().Get("X-Error"), "yes")
+	ExpectEqual(t, w.Header().Get("X-Page"), "")
+	ExpectEqual(t, w.Header().Get("Cache-Control"), "")
+
+	// without an error page, a plain 500 is sent
+	handler.errorHandler = nil
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ExpectEqual(t, w.Code, http.StatusInternalServerError)
+	ExpectEqual(t, w.Body.String(), "Error executing template\n")
+	ExpectEqual(t, w.Header().Get("X-Page"), "")
+}
+
+func TestTemplateHandlerMeta(t *testing.T) {
+	meta, text, err := ParseFrontMatter("---\n" +
+		"status: 202\n" +
+		"cache-control: no-store\n" +
+		"header.x-frame-options: DENY\n" +
+		"header.Content-Security-Policy: default-src 'self'\n" +
+		"---\n" +
+		"<p>{{.Meta.Status}}</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templ := template.Must(template.New("page").Parse(text))
+	tests := []struct {
+		name        string
+		contentType string
+		stream      bool
+		want        string
+	}{
+		{"page", "", false, htmlContentType},
+		{"streamed page", "", true, htmlContentType},
+		{"resource", "application/xml", false, "application/xml"},
+	}
+	for _, test := range tests {
+		handler := &TemplateHandler{
+			template:    templ,
+			data:        PageData{Meta: meta},
+			contentType: test.contentType,
+			stream:      test.stream,
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusAccepted || w.Body.String() != "<p>202</p>" {
+			t.Errorf("%s: %d %s", test.name, w.Code, w.Body.String())
+		}
+		header := w.Header()
+		if header.Get("Content-Type") != test.want || header.Get("Cache-Control") != "no-store" ||
+			header.Get("X-Frame-Options") != "DENY" || header.Get("Content-Security-Policy") != "default-src 'self'" {
+			t.Errorf("%s: headers = %v", test.name, header)
+		}
+		if streamed := header.Get("Content-Length") == ""; streamed != test.stream {
+			t.Errorf("%s: streamed = %t", test.name, streamed)
+		}
+	}
+}
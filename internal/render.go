@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// maxPooledBufferSize keeps unusually large renders from pinning memory in the pool.
+const maxPooledBufferSize = 1 << 20
+
+// streamBufferSize is how much output is collected before a streamed render is
+// flushed to the client.
+const streamBufferSize = 4096
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// GetBuffer returns an empty buffer from the pool.
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer resets buf and returns it to the pool.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// Executor is a parsed template that can be executed. It is satisfied by both
+// html/template and text/template.
+type Executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// RenderTemplate executes templ into a pooled buffer and only writes to w if
+// execution succeeds, so a failed render leaves the response untouched. The
+// status code is only written if it is non-zero.
+func RenderTemplate(w http.ResponseWriter, status int, contentType string, templ Executor, data any) error {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	err := templ.Execute(buf, data)
+	if err != nil {
+		return err
+	}
+	return WriteBuffer(w, status, contentType, buf)
+}
+
+// WriteBuffer writes the headers and the contents of buf to w.
+func WriteBuffer(w http.ResponseWriter, status int, contentType string, buf *bytes.Buffer) error {
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// StreamTemplate executes templ directly into w, flushing the output to the
+// client in small chunks. Headers are committed before execution starts, so an
+// error part way through can only be logged and the response is cut short.
+func StreamTemplate(w http.ResponseWriter, status int, contentType string, templ Executor, data any) error {
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	bw := bufio.NewWriterSize(&flushWriter{w: w, rc: http.NewResponseController(w)}, streamBufferSize)
+	err := templ.Execute(bw, data)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// flushWriter flushes the response after every write.
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	err = fw.rc.Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	templ := template.Must(template.New("page").Parse(`<p>{{.Name}}</p>`))
+	w := httptest.NewRecorder()
+	err := RenderTemplate(w, http.StatusCreated, htmlContentType, templ, map[string]string{"Name": "a&b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, w.Code, http.StatusCreated)
+	ExpectEqual(t, w.Body.String(), "<p>a&amp;b</p>")
+	ExpectEqual(t, w.Header().Get("Content-Type"), htmlContentType)
+	ExpectEqual(t, w.Header().Get("Content-Length"), "14")
+
+	// a template failing part way leaves the response untouched
+	failing := template.Must(template.New("page").Parse(`<p>rendered</p>{{.Name.Missing}}`))
+	w = httptest.NewRecorder()
+	err = RenderTemplate(w, http.StatusCreated, htmlContentType, failing, map[string]string{"Name": "a"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	ExpectEqual(t, w.Body.Len(), 0)
+	ExpectEqual(t, len(w.Header()), 0)
+	ExpectEqual(t, w.Flushed, false)
+	// the status has not been written yet, so an error response can still be sent
+	http.Error(w, "error", http.StatusInternalServerError)
+	ExpectEqual(t, w.Code, http.StatusInternalServerError)
+}
+
+func TestWriteBuffer(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := WriteBuffer(w, 0, "", bytes.NewBufferString("héllo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, w.Code, http.StatusOK)
+	ExpectEqual(t, w.Header().Get("Content-Length"), "6")
+	ExpectEqual(t, w.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	ExpectEqual(t, w.Body.String(), "héllo")
+
+	w = httptest.NewRecorder()
+	err = WriteBuffer(w, http.StatusNoContent, "application/json", new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, w.Code, http.StatusNoContent)
+	ExpectEqual(t, w.Header().Get("Content-Length"), "0")
+	ExpectEqual(t, w.Header().Get("Content-Type"), "application/json")
+}
+
+func TestStreamTemplate(t *testing.T) {
+	w := httptest.NewRecorder()
+	// sent records how much of the page had reached the client when the
+	// template got to it
+	var sent []int
+	templ := texttemplate.Must(texttemplate.New("page").Funcs(texttemplate.FuncMap{
+		"sent": func() string {
+			sent = append(sent, w.Body.Len())
+			return ""
+		},
+	}).Parse(`{{sent}}{{.}}{{sent}}{{.}}{{sent}}`))
+	chunk := strings.Repeat("a", streamBufferSize+1)
+	err := StreamTemplate(w, http.StatusAccepted, "text/plain", templ, chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpectEqual(t, w.Code, http.StatusAccepted)
+	ExpectEqual(t, w.Header().Get("Content-Type"), "text/plain")
+	ExpectEqual(t, w.Header().Get("Content-Length"), "")
+	ExpectEqual(t, w.Flushed, true)
+	ExpectEqual(t, w.Body.String(), chunk+chunk)
+	// nothing is sent before the first chunk fills up, and each full chunk is
+	// flushed before the page is done
+	if len(sent) != 3 || sent[0] != 0 || sent[1] == 0 || sent[2] <= sent[1] {
+		t.Errorf("sent = %v", sent)
+	}
+
+	// an error cuts the response short, after the status and the first chunk
+	// are sent
+	w = httptest.NewRecorder()
+	failing := template.Must(template.New("page").Parse(`{{.}}{{.Missing}}`))
+	err = StreamTemplate(w, 0, htmlContentType, failing, chunk)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	ExpectEqual(t, w.Code, http.StatusOK)
+	ExpectEqual(t, w.Body.String(), chunk)
+}
@@ -1,36 +1,52 @@
 package internal
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/gomxapp/gomx/config"
 	"html/template"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	texttemplate "text/template"
 )
 
 type RouteMaker interface {
 	GetRouteTree() *RouteTree
 }
 
-type fileBasedRouteMaker struct{}
+type fileBasedRouteMaker struct {
+	// assets are looked up by the asset function of the templates.
+	assets *AssetRegistry
+}
 
-func FileBasedRouteMaker() RouteMaker {
-	return &fileBasedRouteMaker{}
+func FileBasedRouteMaker(assets *AssetRegistry) RouteMaker {
+	return &fileBasedRouteMaker{assets: assets}
 }
 
 func (maker *fileBasedRouteMaker) GetRouteTree() *RouteTree {
-	rt := createFileBasedRouteTree()
+	rt := createFileBasedRouteTree(assetFuncs(maker.assets))
 	return rt
 }
 
-func createFileBasedRouteTree() *RouteTree {
+// createFileBasedRouteTree creates the route tree of the routes directory. The
+// templates get funcs in addition to the shared template functions.
+func createFileBasedRouteTree(funcs template.FuncMap) *RouteTree {
 	rootNode := createRoot()
+	// shared partials are available to every page in the tree
+	err := ReloadSharedTemplates()
+	if err != nil {
+		log.Fatalf("Error parsing shared templates\n\t%v\n", err)
+	}
 
 	// Walks the directory given by dirPath, creates tree nodes and adds them to the parent
-	var helper func(*RouteTree, string)
-	helper = func(parent *RouteTree, dirPath string) {
+	// errorFilePath is the closest 500 page from the parent directories.
+	var helper func(*RouteTree, string, string)
+	helper = func(parent *RouteTree, dirPath string, errorFilePath string) {
 		var pathSegment string
 		if dirPath == config.RoutesDir {
 			pathSegment = "/"
@@ -42,17 +58,23 @@ func createFileBasedRouteTree() *RouteTree {
 			return
 		}
 		var pageFiles []os.DirEntry
+		var resourceFiles []os.DirEntry
 		var subDirs []os.DirEntry
 		for _, entry := range entries {
 			if entry.Name()[0] == '_' {
 				continue
 			}
 			if entry.IsDir() {
+				// the reserved directory holds partials, not routes
+				if filepath.ToSlash(filepath.Join(dirPath, entry.Name())) == config.ReservedDir {
+					continue
+				}
 				subDirs = append(subDirs, entry)
 			} else {
-				if strings.HasSuffix(entry.Name(), ".html") ||
-					strings.HasSuffix(entry.Name(), ".gohtml") {
+				if isTemplateFile(entry.Name()) {
 					pageFiles = append(pageFiles, entry)
+				} else if strings.HasSuffix(entry.Name(), resourceTemplateSuffix) {
+					resourceFiles = append(resourceFiles, entry)
 				}
 			}
 		}
@@ -69,6 +91,11 @@ func createFileBasedRouteTree() *RouteTree {
 				notFoundFilePath = filepath.Join(dirPath, file.Name())
 				continue
 			}
+			// errorFile found, used by this directory and its subdirectories
+			if file.Name() == "500.html" || file.Name() == "500.gohtml" {
+				errorFilePath = filepath.Join(dirPath, file.Name())
+				continue
+			}
 			if file.Name() == pathSegment+".html" || file.Name() == pathSegment+".gohtml" {
 				if rootFileIndex != -1 {
 					log.Fatalf("Error parsing routes\n\tAmbiguous root file. Multiple files named: %s\n", pathSegment)
@@ -85,40 +112,199 @@ func createFileBasedRouteTree() *RouteTree {
 			fileFullPaths[0] = fileFullPaths[rootFileIndex]
 			fileFullPaths[rootFileIndex] = temp
 		}
-		// add base template to beginning of slice
-		fileFullPaths = append([]string{config.BaseTemplate}, fileFullPaths...)
 		// page handler
-		templ, err := template.ParseFiles(fileFullPaths...)
+		templ, meta, err := parsePageTemplate(fileFullPaths, funcs)
 		if err != nil {
 			log.Fatalf("Error generating page template\n\t%v\n", err)
 		}
+		// error handler
+		var errorHandler http.Handler
+		if errorFilePath != "" {
+			errorHandler = createErrorPageHandler(templ, errorFilePath, http.StatusInternalServerError, nil)
+		}
 		currentNode.handler = &TemplateHandler{
-			template: templ,
+			template:     templ,
+			data:         PageData{Meta: meta},
+			errorHandler: errorHandler,
+			stream:       meta.streams(),
 		}
 		// not found handler
 		if notFoundFilePath != "" {
-			notFoundTempl, err := templ.Clone()
-			if err != nil {
-				log.Fatalf("Error generating error page template\n\t%v\n", err)
-			}
-			notFoundTempl, err = notFoundTempl.ParseFiles(notFoundFilePath)
-			if err != nil {
-				log.Fatalf("Error generating error page template\n\t%v\n", err)
-			}
-			currentNode.notFoundHandler = &TemplateHandler{
-				template: notFoundTempl,
-			}
+			currentNode.notFoundHandler = createErrorPageHandler(templ, notFoundFilePath, http.StatusNotFound, errorHandler)
 		}
 		err = parent.AddChild(currentNode)
 		if err != nil {
 			log.Fatalln(err)
 		}
+		guard, err := readGuardFile(dirPath)
+		if err != nil {
+			log.Fatalf("Error parsing guard file\n\t%v\n", err)
+		}
+		if guard != nil {
+			guard.Dir = currentNode.Pattern()
+			currentNode.guard = guard
+			rootNode.guards = append(rootNode.guards, *guard)
+		}
+
+		// non-HTML resources are served next to the page, e.g. /feed.xml
+		for _, file := range resourceFiles {
+			resourceNode, err := createResourceNode(filepath.Join(dirPath, file.Name()), funcs)
+			if err != nil {
+				log.Fatalf("Error generating resource template\n\t%v\n", err)
+			}
+			err = currentNode.AddChild(resourceNode)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
 
 		// parse subdirs
 		for _, subDir := range subDirs {
-			helper(currentNode, filepath.Join(dirPath, subDir.Name()+"/"))
+			helper(currentNode, filepath.Join(dirPath, subDir.Name()+"/"), errorFilePath)
 		}
 	}
-	helper(rootNode, config.RoutesDir)
+	helper(rootNode, config.RoutesDir, "")
 	return rootNode
 }
+
+// resourceTemplateSuffix marks a template for a non-HTML resource in the routes
+// directory. The rest of the file name is the route, and its extension picks
+// the content type, e.g. feed.xml.gotmpl is served at feed.xml as XML.
+const resourceTemplateSuffix = ".gotmpl"
+
+// escapedResourceExts are the resources rendered with html/template, which
+// escapes values for their context. SVG is among them since browsers run the
+// scripts in SVG images they are sent directly.
+var escapedResourceExts = []string{".html", ".htm", ".svg"}
+
+// textResourceFuncs are added to the functions of resource templates rendered
+// with text/template, which escapes nothing by itself.
+var textResourceFuncs = texttemplate.FuncMap{
+	"json": jsonString,
+}
+
+// jsonString is the json function of text resources. It encodes v as JSON,
+// with <, >, and & escaped.
+func jsonString(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// createResourceNode creates a route for a non-HTML resource template. The
+// template is rendered without the base template, using html/template for
+// HTML and SVG resources and text/template for everything else. Values in
+// text resources must be escaped by the template, e.g. with html in XML and
+// json in JSON. The template gets funcs in addition to the shared template
+// functions.
+func createResourceNode(filePath string, funcs template.FuncMap) (*RouteTree, error) {
+	routeName := strings.TrimSuffix(filepath.Base(filePath), resourceTemplateSuffix)
+	ext := strings.ToLower(filepath.Ext(routeName))
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	meta, text, err := readPageFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var templ Executor
+	if slices.Contains(escapedResourceExts, ext) {
+		htmlTempl, err := SharedTemplates()
+		if err != nil {
+			return nil, err
+		}
+		templ, err = htmlTempl.Funcs(funcs).New(routeName).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		templ, err = texttemplate.New(routeName).Funcs(texttemplate.FuncMap(templateFuncs)).Funcs(texttemplate.FuncMap(funcs)).Funcs(textResourceFuncs).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	handler := &TemplateHandler{
+		template:    templ,
+		data:        PageData{Meta: meta},
+		contentType: contentType,
+		stream:      meta.streams(),
+	}
+	return createNode(routeName, http.MethodGet, handler, nil), nil
+}
+
+// parsePageTemplate parses the page files, without their front matter, into a
+// clone of the shared partials along with the page's layout. It returns the
+// template to execute and the page metadata, where the front matter of earlier
+// files takes precedence over later ones. The template gets funcs in addition
+// to the shared template functions.
+func parsePageTemplate(files []string, funcs template.FuncMap) (*template.Template, PageMeta, error) {
+	var meta PageMeta
+	texts := make([]string, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		fileMeta, text, err := readPageFile(files[i])
+		if err != nil {
+			return nil, meta, err
+		}
+		meta.merge(fileMeta)
+		texts[i] = text
+	}
+	layout := config.BaseTemplate
+	if meta.Layout == LayoutNone {
+		layout = ""
+	} else if meta.Layout != "" {
+		layout = filepath.Join(config.AppRootDir, meta.Layout)
+	}
+	templ, err := SharedTemplates()
+	if err != nil {
+		return nil, meta, err
+	}
+	templ.Funcs(funcs)
+	var execName string
+	if layout != "" {
+		templ, err = templ.ParseFiles(layout)
+		if err != nil {
+			return nil, meta, err
+		}
+		execName = filepath.Base(layout)
+	}
+	for i, file := range files {
+		_, err = templ.New(filepath.Base(file)).Parse(texts[i])
+		if err != nil {
+			return nil, meta, err
+		}
+		if execName == "" {
+			execName = filepath.Base(file)
+		}
+	}
+	if execName == "" {
+		return nil, meta, errors.New("page has neither a layout nor any page files")
+	}
+	return templ.Lookup(execName), meta, nil
+}
+
+// createErrorPageHandler returns a handler for an error page made by parsing
+// filePath into a clone of the page template. The page uses the front matter
+// of filePath, and is served with the given status unless the front matter
+// declares one.
+func createErrorPageHandler(pageTempl *template.Template, filePath string, status int, errorHandler http.Handler) *TemplateHandler {
+	meta, text, err := readPageFile(filePath)
+	if err != nil {
+		log.Fatalf("Error generating error page template\n\t%v\n", err)
+	}
+	templ, err := pageTempl.Clone()
+	if err != nil {
+		log.Fatalf("Error generating error page template\n\t%v\n", err)
+	}
+	_, err = templ.New(filepath.Base(filePath)).Parse(text)
+	if err != nil {
+		log.Fatalf("Error generating error page template\n\t%v\n", err)
+	}
+	if meta.Status == 0 {
+		meta.Status = status
+	}
+	return &TemplateHandler{
+		template:     templ,
+		data:         PageData{Meta: meta},
+		errorHandler: errorHandler,
+	}
+}
@@ -20,49 +20,60 @@ const (
 // ----------------- ROUTE TREE WRAPPER
 
 type RouteTreeWrapper struct {
-	Tree        *RouteTree
-	closestNode *RouteTree
-	matchLvl    MatchLevel
+	Tree *RouteTree
 }
 
-func (wrapper *RouteTreeWrapper) ServeNotFound(w http.ResponseWriter, r *http.Request) {
-	if n := wrapper.closestNode; n != nil && n.notFoundHandler != nil {
-		n.notFoundHandler.ServeHTTP(w, r)
-	} else {
-		http.NotFound(w, r)
+// Match is the result of matching a single request against the route tree.
+// Matches are computed per request, so they are safe to use concurrently.
+type Match struct {
+	Node  *RouteTree
+	Level MatchLevel
+	path  string
+}
+
+// Match finds the node in the tree that best matches the request.
+func (wrapper *RouteTreeWrapper) Match(r *http.Request) Match {
+	path := r.URL.EscapedPath()
+	node, level := wrapper.Tree.FindClosestMatchingNode(path, r.Method)
+	return Match{
+		Node:  node,
+		Level: level,
+		path:  path,
 	}
 }
 
-func (wrapper *RouteTreeWrapper) setClosestMatch(path string, method string) {
-	wrapper.closestNode, wrapper.matchLvl = wrapper.Tree.FindClosestMatchingNode(path, method)
+// IsExact returns whether the request matched a route with a handler.
+func (match Match) IsExact() bool {
+	return match.Level >= WildMatch && match.Node != nil && match.Node.handler != nil
 }
 
-func (wrapper *RouteTreeWrapper) setPathValues(r *http.Request) {
-	if wrapper.matchLvl != WildMatch {
+// SetPathValues sets the values of the wildcards in the matched route on r.
+func (match Match) SetPathValues(r *http.Request) {
+	// not checking for WildMatch, which is only reported when the
+	// wildcard is the last part of the path
+	if match.Node == nil {
 		return
 	}
-	match := wrapper.closestNode
-	nodes, err := match.GetPathFromRoot(false)
+	nodes, err := match.Node.GetPathFromRoot(false)
 	if err != nil {
 		panic("error getting path from root")
 	}
-	for _, n := range nodes {
-		if n.isWild {
-			r.SetPathValue(n.pathPart, n.wildData)
+	// the node at depth d matched the path part at index d-1
+	targetParts := strings.Split(strings.TrimRight(match.path, "/"), "/")
+	for i, n := range nodes {
+		if n.isWild && i < len(targetParts) {
+			r.SetPathValue(n.pathPart, targetParts[i])
 		}
 	}
 }
 
-func (wrapper *RouteTreeWrapper) ContainsExactMatch(request *http.Request) bool {
-	path := request.URL.EscapedPath()
-	method := request.Method
-	wrapper.setClosestMatch(path, method)
-	wrapper.setPathValues(request)
-	return wrapper.matchLvl >= WildMatch && wrapper.closestNode != nil && wrapper.closestNode.handler != nil
-}
-
-func (wrapper *RouteTreeWrapper) ServeClosestMatch(w http.ResponseWriter, r *http.Request) {
-	wrapper.closestNode.ServeHTTP(w, r)
+// ServeNotFound serves the closest not found handler above the matched node.
+func (match Match) ServeNotFound(w http.ResponseWriter, r *http.Request) {
+	if n := match.Node.FindClosestNotFoundHandler(); n != nil {
+		n.notFoundHandler.ServeHTTP(w, r)
+	} else {
+		http.NotFound(w, r)
+	}
 }
 
 func (wrapper *RouteTreeWrapper) String() string {
@@ -79,7 +90,10 @@ type RouteTree struct {
 	parent          *RouteTree
 	children        []*RouteTree
 	isWild          bool
-	wildData        string
+	// guard is the guard file of the node's directory, or nil.
+	guard *Guard
+	// guards are the guard files of the tree, set on the root.
+	guards []Guard
 }
 
 func createRoot() *RouteTree {
@@ -190,19 +204,30 @@ func (tree *RouteTree) AddRelativeChild(relPath string, method string, handler h
 	parts := strings.Split(relPath, "/")
 	for _, pathPart := range parts {
 		newNode := createNode(pathPart, method, nil, nil)
-		err := curr.AddChild(newNode)
-		if err != nil {
-			// only here if child already exists
-			curr = curr.Go(newNode.pathPart, newNode.method)
-			continue
-		}
-		curr = newNode
+		// errors only if the child already exists. Either way, continue
+		// from the child that is in the tree, since AddChild may have merged
+		// newNode into an existing one.
+		_ = curr.AddChild(newNode)
+		curr = curr.Go(newNode.pathPart, newNode.method)
 	}
 	curr.handler = handler
 	curr.notFoundHandler = notFoundHandler
 	return curr, nil
 }
 
+// FindRelativeChild returns the node AddRelativeChild would add at relPath,
+// or nil if the tree has none.
+func (tree *RouteTree) FindRelativeChild(relPath string, method string) *RouteTree {
+	curr := tree
+	for _, pathPart := range strings.Split(relPath, "/") {
+		if curr == nil {
+			return nil
+		}
+		curr = curr.Go(createNode(pathPart, method, nil, nil).pathPart, method)
+	}
+	return curr
+}
+
 // GetPath returns the full match path at the current node. Except for the root
 // node, GetPath appends a trailing-slash to the full path.
 func (tree *RouteTree) GetPath() string {
@@ -227,6 +252,66 @@ func (tree *RouteTree) GetPath() string {
 	return out
 }
 
+// Pattern returns the route pattern of the node, with wildcards in braces and
+// without a trailing slash, e.g. "/items/{id}".
+func (tree *RouteTree) Pattern() string {
+	nodes, err := tree.GetPathFromRoot(false)
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for _, n := range nodes {
+		if n.isWild {
+			parts = append(parts, wildcardPrefix+n.pathPart+wildcardSuffix)
+		} else if n.pathPart != "" {
+			parts = append(parts, n.pathPart)
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// Guards returns the guards declared in the guard files of the tree, parents
+// before their subdirectories.
+func (tree *RouteTree) Guards() []Guard {
+	return tree.guards
+}
+
+// PageGuard returns the guard declared in the front matter of the node's page,
+// or nil.
+func (tree *RouteTree) PageGuard() *Guard {
+	if handler, ok := tree.handler.(*TemplateHandler); ok {
+		return handler.data.Meta.Guard
+	}
+	return nil
+}
+
+// DirGuard returns the guard file of the closest directory above the node,
+// starting with its own, or nil.
+func (tree *RouteTree) DirGuard() *Guard {
+	for n := tree; n != nil; n = n.parent {
+		if n.guard != nil {
+			return n.guard
+		}
+	}
+	return nil
+}
+
+// Method returns the HTTP method the node matches.
+func (tree *RouteTree) Method() string {
+	return tree.method
+}
+
+// Handler returns the node's handler.
+func (tree *RouteTree) Handler() http.Handler {
+	return tree.handler
+}
+
+// IsPage returns whether the node serves a page from the routes directory.
+func (tree *RouteTree) IsPage() bool {
+	_, ok := tree.handler.(*TemplateHandler)
+	return ok
+}
+
 // GetPathFromRoot returns all nodes to reach this node as a *RouteTree slice
 // ordered starting from the root.
 func (tree *RouteTree) GetPathFromRoot(includeRoot bool) ([]*RouteTree, error) {
@@ -317,10 +402,6 @@ func (tree *RouteTree) FindClosestMatchingNode(targetPath string, targetMethod s
 				bestCandidateDepth = i
 				bestCandidate = node
 			}
-			// set wild data
-			if candidate.isWild {
-				candidate.wildData = tp
-			}
 		}
 		return bestCandidateDepth, bestCandidate, isWildPath || bestCandidate.isWild
 	}
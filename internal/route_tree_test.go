@@ -132,7 +132,9 @@ func TestRouteTree(t *testing.T) {
 		expectedPath := "/e/z/"
 		ExpectEqual(t, matchLevel, expectedMatchLevel)
 		ExpectEqual(t, closestNodePath, expectedPath)
-		ExpectEqual(t, closestNode.wildData, wildData)
+		match := (&RouteTreeWrapper{Tree: tree}).Match(mockRequest)
+		match.SetPathValues(mockRequest)
+		ExpectEqual(t, mockRequest.PathValue("z"), wildData)
 	})
 
 	// ------------- TEST NOT FOUND HANDLING
@@ -168,3 +170,22 @@ func TestRouteTree(t *testing.T) {
 		ExpectEqual(t, mockWriter.Body.String(), "c")
 	})
 }
+
+func TestAddRelativeChild(t *testing.T) {
+	tree := createRoot()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	for _, path := range []string{"/items/{id}", "/users/{id}/comments", "/users"} {
+		_, err := tree.AddRelativeChild(path, http.MethodPost, handler, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"/items/1", "/users/1/comments", "/users"} {
+		closestNode, matchLevel := tree.FindClosestMatchingNode(path, http.MethodPost)
+		ExpectEqual(t, matchLevel >= WildMatch, true)
+		ExpectEqual(t, closestNode.handler != nil, true)
+	}
+	ExpectEqual(t, len(tree.children), 1)
+}
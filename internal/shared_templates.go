@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"github.com/gomxapp/gomx/config"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sharedTemplateName = "gomx-shared"
+
+var shared struct {
+	sync.Mutex
+	templ    *template.Template
+	files    []string
+	modTimes []time.Time
+	// version is incremented every time the shared partials are reloaded
+	version uint64
+}
+
+// isTemplateFile returns whether the file name has an extension that gomx
+// parses as an HTML template.
+func isTemplateFile(name string) bool {
+	return strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".gohtml")
+}
+
+// isSharedPath returns whether path lives in the reserved directory or in an
+// underscore-prefixed directory under config.RoutesDir.
+func isSharedPath(path string) bool {
+	path = filepath.ToSlash(path)
+	if strings.HasPrefix(path, config.ReservedDir+"/") {
+		return true
+	}
+	rel, err := filepath.Rel(config.RoutesDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// SharedTemplateFiles returns the paths of every template file in the reserved
+// directory and in underscore-prefixed directories under config.RoutesDir.
+func SharedTemplateFiles() []string {
+	var files []string
+	seen := make(map[string]bool)
+	walk := func(root string) {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !isTemplateFile(d.Name()) {
+				return nil
+			}
+			if !seen[path] && isSharedPath(path) {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	walk(config.RoutesDir)
+	walk(config.ReservedDir)
+	return files
+}
+
+func loadSharedTemplates(files []string) (*template.Template, error) {
+	templ := template.New(sharedTemplateName).Funcs(templateFuncs)
+	if len(files) == 0 {
+		return templ, nil
+	}
+	return templ.ParseFiles(files...)
+}
+
+// reloadSharedTemplatesLocked parses the shared partials from disk. The caller
+// must hold the shared lock.
+func reloadSharedTemplatesLocked() error {
+	files := SharedTemplateFiles()
+	modTimes := statModTimes(files)
+	templ, err := loadSharedTemplates(files)
+	if err != nil {
+		return err
+	}
+	shared.templ = templ
+	shared.files = files
+	shared.modTimes = modTimes
+	shared.version++
+	return nil
+}
+
+// ReloadSharedTemplates parses the shared partials from disk, replacing the
+// previously loaded set.
+func ReloadSharedTemplates() error {
+	shared.Lock()
+	defer shared.Unlock()
+	return reloadSharedTemplatesLocked()
+}
+
+// SharedTemplatesVersion returns a number that changes whenever the shared
+// partials are reloaded. In dev mode, the partials are reloaded first if any
+// of their files were added, removed, or modified.
+func SharedTemplatesVersion() (uint64, error) {
+	shared.Lock()
+	defer shared.Unlock()
+	if shared.templ == nil || (config.DevMode && sharedTemplatesChanged()) {
+		err := reloadSharedTemplatesLocked()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return shared.version, nil
+}
+
+func sharedTemplatesChanged() bool {
+	files := SharedTemplateFiles()
+	if !slices.Equal(files, shared.files) {
+		return true
+	}
+	return !slices.Equal(statModTimes(files), shared.modTimes)
+}
+
+// SharedTemplates returns a fresh clone of the shared partials. Pages, error
+// pages, and API templates parse their own files into the clone so that every
+// partial can be used with {{template "name" .}}.
+//
+// The partials are loaded on first use if ReloadSharedTemplates has not been
+// called yet.
+func SharedTemplates() (*template.Template, error) {
+	shared.Lock()
+	defer shared.Unlock()
+	if shared.templ == nil {
+		err := reloadSharedTemplatesLocked()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return shared.templ.Clone()
+}
+
+// statModTimes returns the modification time of each file. Files that cannot
+// be read get the zero time.
+func statModTimes(files []string) []time.Time {
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// ParseWithShared parses files into a clone of the shared partials and returns
+// the template named after the first file, so that executing it behaves like
+// executing the result of template.ParseFiles.
+func ParseWithShared(files ...string) (*template.Template, error) {
+	templ, err := SharedTemplates()
+	if err != nil {
+		return nil, err
+	}
+	templ, err = templ.ParseFiles(files...)
+	if err != nil {
+		return nil, err
+	}
+	return templ.Lookup(filepath.Base(files[0])), nil
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/gomxapp/gomx/config"
+	"html/template"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APITemplates caches the templates parsed by the API render helpers.
+var APITemplates = NewTemplateCache()
+
+// maxStringTemplates is how many templates parsed from text a TemplateCache
+// keeps. Text built at runtime would otherwise grow the cache without bound.
+const maxStringTemplates = 256
+
+type cachedTemplate struct {
+	templ         *template.Template
+	files         []string
+	modTimes      []time.Time
+	sharedVersion uint64
+	// element is the entry's key in TemplateCache.recent, or nil for entries
+	// parsed from files.
+	element *list.Element
+}
+
+// isStale returns whether the entry must be parsed again. Outside of dev mode
+// only a reload of the shared partials invalidates an entry.
+func (entry *cachedTemplate) isStale(sharedVersion uint64) bool {
+	if entry.sharedVersion != sharedVersion {
+		return true
+	}
+	if !config.DevMode || len(entry.files) == 0 {
+		return false
+	}
+	return !slices.Equal(statModTimes(entry.files), entry.modTimes)
+}
+
+// TemplateCache is a concurrency-safe cache of parsed templates keyed by the
+// set of files (or the template text) and the template name. In dev mode,
+// entries are parsed again when one of their files changes.
+type TemplateCache struct {
+	mu      sync.RWMutex
+	entries map[string]*cachedTemplate
+	preload [][]string
+	// recent holds the keys of the entries parsed from text, most recently
+	// used first. Past maxStrings of them, the least recently used is evicted.
+	recent     *list.List
+	maxStrings int
+}
+
+func NewTemplateCache() *TemplateCache {
+	return &TemplateCache{
+		entries:    make(map[string]*cachedTemplate),
+		recent:     list.New(),
+		maxStrings: maxStringTemplates,
+	}
+}
+
+// Files returns the template called name parsed from files along with the
+// shared partials. If name is empty, the template of the first file is returned.
+func (cache *TemplateCache) Files(files []string, name string) (*template.Template, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no template files given for %q", name)
+	}
+	key := "files:" + strings.Join(files, "\x00") + "\x00" + name
+	return cache.get(key, files, func() (*template.Template, error) {
+		templ, err := ParseWithShared(files...)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return templ, nil
+		}
+		named := templ.Lookup(name)
+		if named == nil {
+			return nil, fmt.Errorf("template %q is not defined in %v", name, files)
+		}
+		return named, nil
+	})
+}
+
+// String returns the template parsed from text along with the shared partials.
+// Only the most recently used texts are kept, so text should be a constant or
+// come from a small set; building it from request data just parses it again
+// every time.
+func (cache *TemplateCache) String(text string) (*template.Template, error) {
+	return cache.get("string:"+text, nil, func() (*template.Template, error) {
+		templ, err := SharedTemplates()
+		if err != nil {
+			return nil, err
+		}
+		return templ.New("tmp").Parse(text)
+	})
+}
+
+// AddPreload records a set of files to be parsed by Preload.
+func (cache *TemplateCache) AddPreload(files []string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.preload = append(cache.preload, files)
+}
+
+// Preload parses every file set recorded with AddPreload so that parse errors
+// surface at startup instead of on the first request.
+func (cache *TemplateCache) Preload() error {
+	cache.mu.RLock()
+	preload := slices.Clone(cache.preload)
+	cache.mu.RUnlock()
+	for _, files := range preload {
+		_, err := cache.Files(files, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cache *TemplateCache) get(key string, files []string, parse func() (*template.Template, error)) (*template.Template, error) {
+	sharedVersion, err := SharedTemplatesVersion()
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.RLock()
+	entry, ok := cache.entries[key]
+	cache.mu.RUnlock()
+	if ok && !entry.isStale(sharedVersion) {
+		if entry.element != nil {
+			cache.mu.Lock()
+			// a no-op if the entry was evicted meanwhile
+			cache.recent.MoveToFront(entry.element)
+			cache.mu.Unlock()
+		}
+		return entry.templ, nil
+	}
+	// stat before parsing so that a change made during parsing is picked up
+	// by the next lookup
+	modTimes := statModTimes(files)
+	templ, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	entry = &cachedTemplate{
+		templ:         templ,
+		files:         slices.Clone(files),
+		modTimes:      modTimes,
+		sharedVersion: sharedVersion,
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if old, ok := cache.entries[key]; ok && old.element != nil {
+		cache.recent.Remove(old.element)
+	}
+	cache.entries[key] = entry
+	if files == nil {
+		entry.element = cache.recent.PushFront(key)
+		for cache.recent.Len() > cache.maxStrings {
+			delete(cache.entries, cache.recent.Remove(cache.recent.Back()).(string))
+		}
+	}
+	return templ, nil
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"github.com/gomxapp/gomx/config"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, content string, modTime time.Time) {
+	err := os.MkdirAll(filepath.Dir(path), 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(content), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func executeToString(t *testing.T, cache *TemplateCache, files []string, name string) string {
+	templ, err := cache.Files(files, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	err = templ.Execute(&sb, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestTemplateCache(t *testing.T) {
+	dir := t.TempDir()
+	oldRoutesDir, oldReservedDir, oldDevMode := config.RoutesDir, config.ReservedDir, config.DevMode
+	config.RoutesDir = filepath.ToSlash(filepath.Join(dir, "routes"))
+	config.ReservedDir = config.RoutesDir + "/_"
+	defer func() {
+		config.RoutesDir, config.ReservedDir, config.DevMode = oldRoutesDir, oldReservedDir, oldDevMode
+		_ = ReloadSharedTemplates()
+	}()
+	start := time.Now().Add(-time.Hour)
+	writeTestFile(t, filepath.Join(config.ReservedDir, "button.gohtml"),
+		`{{define "button"}}<button>{{.}}</button>{{end}}`, start)
+	item := filepath.Join(dir, "api", "item.gohtml")
+	writeTestFile(t, item, `<p>{{template "button" .}}</p>`, start)
+	err := ReloadSharedTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("test cached lookups", func(t *testing.T) {
+		cache := NewTemplateCache()
+		first, err := cache.Files([]string{item}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := cache.Files([]string{item}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ExpectEqual(t, first, second)
+		ExpectEqual(t, executeToString(t, cache, []string{item}, ""), "<p><button>data</button></p>")
+		ExpectEqual(t, executeToString(t, cache, []string{item}, "button"), "<button>data</button>")
+		_, err = cache.Files([]string{item}, "missing")
+		ExpectEqual(t, err != nil, true)
+	})
+
+	t.Run("test dev mode invalidation", func(t *testing.T) {
+		cache := NewTemplateCache()
+		config.DevMode = false
+		ExpectEqual(t, executeToString(t, cache, []string{item}, ""), "<p><button>data</button></p>")
+		writeTestFile(t, item, `<div>{{template "button" .}}</div>`, start.Add(time.Minute))
+		ExpectEqual(t, executeToString(t, cache, []string{item}, ""), "<p><button>data</button></p>")
+
+		config.DevMode = true
+		ExpectEqual(t, executeToString(t, cache, []string{item}, ""), "<div><button>data</button></div>")
+		writeTestFile(t, filepath.Join(config.ReservedDir, "button.gohtml"),
+			`{{define "button"}}<a>{{.}}</a>{{end}}`, start.Add(time.Minute))
+		ExpectEqual(t, executeToString(t, cache, []string{item}, ""), "<div><a>data</a></div>")
+	})
+
+	t.Run("test string eviction", func(t *testing.T) {
+		cache := NewTemplateCache()
+		cache.maxStrings = 2
+		parse := func(text string) *template.Template {
+			templ, err := cache.String(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return templ
+		}
+		a, b := parse(`a{{template "button" .}}`), parse("b")
+		ExpectEqual(t, parse(`a{{template "button" .}}`), a)
+		// b is the least recently used, so c evicts it
+		parse("c")
+		ExpectEqual(t, len(cache.entries), 2)
+		ExpectEqual(t, parse(`a{{template "button" .}}`), a)
+		ExpectEqual(t, parse("b") != b, true)
+		ExpectEqual(t, cache.recent.Len(), 2)
+		// file entries are not evicted
+		executeToString(t, cache, []string{item}, "")
+		parse("d")
+		parse("e")
+		ExpectEqual(t, len(cache.entries), 3)
+		_, ok := cache.entries["string:b"]
+		ExpectEqual(t, ok, false)
+	})
+}
@@ -0,0 +1,119 @@
+package gomx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/internal"
+	"net/http"
+)
+
+// ReturnJSONValue encodes v as JSON and writes it with the given status, or
+// 200 if status is 0. In dev mode, the JSON is indented.
+//
+// Like ReturnGoHTML, v is encoded into a buffer first, so if an error is
+// returned nothing has been written.
+func ReturnJSONValue(w http.ResponseWriter, status int, v any) error {
+	return writeJSON(w, status, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	buf := internal.GetBuffer()
+	defer internal.PutBuffer(buf)
+	encoder := json.NewEncoder(buf)
+	if config.DevMode {
+		encoder.SetIndent("", "  ")
+	}
+	err := encoder.Encode(v)
+	if err != nil {
+		return err
+	}
+	return internal.WriteBuffer(w, status, "application/json", buf)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w http.ResponseWriter
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// StreamJSONArray writes a JSON array with the elements each yields, without
+// holding the whole array in memory. It is meant for large results, like rows
+// read from a database:
+//
+//	err := gomx.StreamJSONArray(w, func(yield func(data.Item) error) error {
+//		for rows.Next() {
+//			var item data.Item
+//			err := rows.Scan(&item.ID, &item.Name)
+//			if err != nil {
+//				return err
+//			}
+//			err = yield(item)
+//			if err != nil {
+//				return err
+//			}
+//		}
+//		return rows.Err()
+//	})
+//
+// yield returns an error if the element cannot be encoded or written, and each
+// should then return it. The output is sent to the client in chunks as it
+// grows. If an error occurs before the first chunk is sent, nothing is written
+// and the handler can still respond with an error; after that, the response is
+// cut off, leaving invalid JSON. In dev mode, the JSON is indented.
+func StreamJSONArray[T any](w http.ResponseWriter, each func(yield func(T) error) error) error {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, 8<<10)
+	w.Header().Set("Content-Type", "application/json")
+	separator, end := ",", "]\n"
+	if config.DevMode {
+		separator, end = ",\n  ", "\n]\n"
+	}
+	_, _ = bw.WriteString("[")
+	if config.DevMode {
+		_, _ = bw.WriteString("\n  ")
+	}
+	count := 0
+	var element bytes.Buffer
+	encoder := json.NewEncoder(&element)
+	if config.DevMode {
+		encoder.SetIndent("  ", "  ")
+	}
+	err := each(func(v T) error {
+		element.Reset()
+		err := encoder.Encode(v)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			_, err = bw.WriteString(separator)
+			if err != nil {
+				return err
+			}
+		}
+		count++
+		// Encode adds a newline
+		_, err = bw.Write(bytes.TrimSuffix(element.Bytes(), []byte("\n")))
+		return err
+	})
+	if err != nil {
+		if cw.n == 0 {
+			w.Header().Del("Content-Type")
+		}
+		return err
+	}
+	if count == 0 {
+		bw.Reset(cw)
+		_, _ = bw.WriteString("[]\n")
+	} else {
+		_, _ = bw.WriteString(end)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,59 @@
+package gomx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps a handler with behavior that runs around it, like logging,
+// authentication, or setting headers.
+type Middleware = func(http.Handler) http.Handler
+
+// RouteKind describes what kind of handler a request was routed to.
+type RouteKind int
+
+const (
+	RouteNotFound RouteKind = iota // no route matched, the not found page is served
+	RoutePage                      // a page from the routes directory
+	RouteAPI                       // a registered API
+	RouteStatic                    // a handler added to Router.Mux, like static files
+)
+
+// Route describes the route a request matched.
+type Route struct {
+	// Pattern is the matched route with wildcards in braces, e.g.
+	// "/items/{id}". For handlers on Router.Mux, it is the ServeMux
+	// pattern. It is empty if no route matched.
+	Pattern string
+	Method  string
+	Kind    RouteKind
+}
+
+// MatchedRoute returns the route the router matched for r. It is available to
+// all middleware and handlers run by a Router, and is nil otherwise.
+func MatchedRoute(r *http.Request) *Route {
+	route, _ := r.Context().Value(routeContextKey).(*Route)
+	return route
+}
+
+// Chain wraps handler with middleware so that the first middleware is the
+// outermost one.
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+type dirMiddleware struct {
+	dir        string
+	middleware []Middleware
+}
+
+// contains returns whether urlPath is dir or lies within it.
+func (dm *dirMiddleware) contains(urlPath string) bool {
+	if dm.dir == "/" {
+		return true
+	}
+	return urlPath == dm.dir || strings.HasPrefix(urlPath, dm.dir+"/")
+}
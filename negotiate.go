@@ -0,0 +1,158 @@
+package gomx
+
+import (
+	"fmt"
+	"github.com/gomxapp/gomx/htmx"
+	"github.com/gomxapp/gomx/internal"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const plainContentType = "text/plain; charset=utf-8"
+
+// acceptRange is a media range of an Accept header, e.g. "text/*;q=0.8".
+type acceptRange struct {
+	mediaType string
+	subtype   string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaType, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mediaRange)), "/")
+		if !ok {
+			continue
+		}
+		ar := acceptRange{mediaType: mediaType, subtype: subtype, q: 1}
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				q, err := strconv.ParseFloat(value, 64)
+				if err == nil && q >= 0 && q <= 1 {
+					ar.q = q
+				}
+			}
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// quality returns the q value the most specific matching range gives offer,
+// or -1 if no range matches.
+func quality(ranges []acceptRange, offer string) float64 {
+	mediaType, subtype, _ := strings.Cut(offer, "/")
+	q, specificity := -1.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == mediaType && ar.subtype == subtype:
+			s = 2
+		case ar.mediaType == mediaType && ar.subtype == "*":
+			s = 1
+		case ar.mediaType == "*" && ar.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
+
+// NegotiateContentType returns the offered media type, like "text/html", that
+// the request's Accept header prefers, or an empty string if none is
+// acceptable. Offers are in the server's order of preference, which breaks
+// ties, and the first offer is used if there is no Accept header. htmx
+// requests get "text/html" if it is offered and acceptable.
+func NegotiateContentType(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		if len(offers) == 0 {
+			return ""
+		}
+		if htmx.IsRequest(r) && slices.Contains(offers, "text/html") {
+			return "text/html"
+		}
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	if htmx.IsRequest(r) && slices.Contains(offers, "text/html") && quality(ranges, "text/html") > 0 {
+		return "text/html"
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// wantsHTML returns whether the request comes from htmx or prefers HTML over JSON.
+func wantsHTML(r *http.Request) bool {
+	return NegotiateContentType(r, "application/json", "text/html") == "text/html"
+}
+
+// Negotiation describes the representations of a response, from which
+// ReturnNegotiated picks one.
+type Negotiation struct {
+	// Status defaults to 200.
+	Status int
+	// Data is encoded as JSON, and is the data of the HTML template.
+	Data any
+	// TemplateFiles and TemplateName render the HTML representation, like
+	// ReturnGoHTMLFromFiles. Without files, HTML is not offered.
+	TemplateFiles []string
+	TemplateName  string
+	// Text is the plain text representation. If it is empty, plain text is
+	// only offered when Data is a string or implements fmt.Stringer.
+	Text string
+}
+
+// ReturnNegotiated responds with JSON, HTML, or plain text, whichever the
+// request's Accept header prefers (see NegotiateContentType), so one handler
+// can serve both htmx and API clients. JSON is preferred when the client
+// accepts anything, except for htmx requests, which get HTML.
+//
+// If the client accepts none of the representations, an HTTPError with status
+// 406 is returned. Like the other Return functions, nothing is written if an
+// error is returned, so it can be passed to ReturnError.
+func ReturnNegotiated(w http.ResponseWriter, r *http.Request, n Negotiation) error {
+	offers := []string{"application/json"}
+	if len(n.TemplateFiles) > 0 {
+		offers = append(offers, "text/html")
+	}
+	text, hasText := n.Text, n.Text != ""
+	if !hasText {
+		switch data := n.Data.(type) {
+		case string:
+			text, hasText = data, true
+		case fmt.Stringer:
+			text, hasText = data.String(), true
+		}
+	}
+	if hasText {
+		offers = append(offers, "text/plain")
+	}
+	switch NegotiateContentType(r, offers...) {
+	case "application/json":
+		return writeJSON(w, n.Status, n.Data)
+	case "text/html":
+		t, err := internal.APITemplates.Files(apiFilePaths(n.TemplateFiles), n.TemplateName)
+		if err != nil {
+			return err
+		}
+		return internal.RenderTemplate(w, n.Status, htmlContentType, t, n.Data)
+	case "text/plain":
+		buf := internal.GetBuffer()
+		defer internal.PutBuffer(buf)
+		buf.WriteString(text)
+		return internal.WriteBuffer(w, n.Status, plainContentType, buf)
+	}
+	return NewHTTPError(http.StatusNotAcceptable, "Acceptable representations: "+strings.Join(offers, ", ")+".")
+}
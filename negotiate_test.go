@@ -0,0 +1,75 @@
+package gomx
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "text/html", "text/plain"}
+	tests := []struct {
+		accept string
+		htmx   bool
+		want   string
+	}{
+		{"", false, "application/json"},
+		{"", true, "text/html"},
+		{"*/*", false, "application/json"},
+		{"*/*", true, "text/html"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false, "text/html"},
+		{"text/*", false, "text/html"},
+		{"text/plain, application/json;q=0.5", false, "text/plain"},
+		{"application/json, text/html;q=0", true, "application/json"},
+		{"image/png", false, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		if test.htmx {
+			r.Header.Set("HX-Request", "true")
+		}
+		if got := NegotiateContentType(r, offers...); got != test.want {
+			t.Errorf("Accept %q (htmx %t): got %q, want %q", test.accept, test.htmx, got, test.want)
+		}
+	}
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := StreamJSONArray(w, func(yield func(map[string]int) error) error {
+		for i := range 3 {
+			err := yield(map[string]int{"n": i})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"n":0},{"n":1},{"n":2}]` + "\n"; w.Body.String() != want {
+		t.Errorf("got %q, want %q", w.Body.String(), want)
+	}
+
+	w = httptest.NewRecorder()
+	err = StreamJSONArray(w, func(yield func(int) error) error { return nil })
+	if err != nil || w.Body.String() != "[]\n" {
+		t.Errorf("empty array: %v %q", err, w.Body.String())
+	}
+
+	// an early error leaves the response untouched
+	w = httptest.NewRecorder()
+	failed := errors.New("query failed")
+	err = StreamJSONArray(w, func(yield func(int) error) error {
+		_ = yield(1)
+		return failed
+	})
+	if !errors.Is(err, failed) || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("early error: %v %q %v", err, w.Body.String(), w.Header())
+	}
+}
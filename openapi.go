@@ -0,0 +1,578 @@
+package gomx
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/gomxapp/gomx/config"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the OpenAPI Specification that generated
+// documents follow.
+const openAPIVersion = "3.1.0"
+
+// OpenAPIExportEnv is the environment variable checked by Router.Init. If it is
+// set to a file path, Init writes the OpenAPI document of the router to that
+// file and exits, which is how `gomx openapi` exports the document of an app.
+const OpenAPIExportEnv = "GOMX_OPENAPI_EXPORT"
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	wildcardPattern     = regexp.MustCompile(`\{([^}]+)\}`)
+	schemaNameCleanChar = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// typedAPI is implemented by typed handlers. Their input and output types are
+// described in the OpenAPI document.
+type typedAPI interface {
+	InputType() reflect.Type
+	OutputType() reflect.Type
+	apiOptions() *typedOptions
+}
+
+// documentedHandler keeps the typed handler a route was registered with after
+// it is wrapped in route middleware, so that it can still be documented.
+type documentedHandler struct {
+	http.Handler
+	typed typedAPI
+}
+
+// documentHandler wraps handler so that typedAPIOf finds documented in it.
+func documentHandler(handler http.Handler, documented http.Handler) http.Handler {
+	typed, ok := documented.(typedAPI)
+	if !ok || handler == documented {
+		return handler
+	}
+	return &documentedHandler{Handler: handler, typed: typed}
+}
+
+func typedAPIOf(handler http.Handler) (typedAPI, bool) {
+	switch h := handler.(type) {
+	case *documentedHandler:
+		return h.typed, true
+	case typedAPI:
+		return h, true
+	}
+	return nil, false
+}
+
+// OpenAPI returns the OpenAPI 3.1 document describing the APIs of an
+// initialized router as JSON. Path parameters come from the wildcards in the
+// route paths. Handlers registered with Typed or Handle also get their
+// parameters, request body, and response described from their input and output
+// types, including the constraints in validate tags.
+//
+// The document's title and version are set in gomx.config.json:
+//
+//	"openapi": {"path": "/openapi.json", "title": "Shop", "version": "1.0.0"}
+//
+// If a path is set, the router serves the document at that path.
+func (router *Router) OpenAPI() ([]byte, error) {
+	doc := newOpenAPIDocument(config.OpenAPITitle, config.OpenAPIVersion, router.apiRoutes)
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components *openAPIComponents                      `json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Enum                 []any                     `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+}
+
+// newOpenAPIDocument describes routes, which are sorted by path and method.
+func newOpenAPIDocument(title string, version string, routes []apiRoute) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+	}
+	schemas := newSchemaRegistry()
+	for _, route := range routes {
+		operations, ok := doc.Paths[route.path]
+		if !ok {
+			operations = make(map[string]*openAPIOperation)
+			doc.Paths[route.path] = operations
+		}
+		operations[strings.ToLower(route.method)] = schemas.operation(route)
+	}
+	if len(schemas.schemas) > 0 {
+		doc.Components = &openAPIComponents{Schemas: schemas.schemas}
+	}
+	return doc
+}
+
+// schemaRegistry collects the schemas of named struct types, which are added
+// to the document's components and referenced by name.
+type schemaRegistry struct {
+	schemas map[string]*openAPISchema
+	names   map[reflect.Type]string
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		schemas: make(map[string]*openAPISchema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+func (sr *schemaRegistry) operation(route apiRoute) *openAPIOperation {
+	op := &openAPIOperation{
+		Responses: make(map[string]*openAPIResponse),
+	}
+	typed, ok := typedAPIOf(route.handler)
+	if !ok {
+		op.Parameters = pathParameters(route.path, nil)
+		op.Responses["default"] = &openAPIResponse{Description: "Response of an untyped handler"}
+		return op
+	}
+	options := typed.apiOptions()
+	op.Summary = options.summary
+	op.Description = options.description
+
+	in := typed.InputType()
+	for in.Kind() == reflect.Pointer {
+		in = in.Elem()
+	}
+	var params []*openAPIParameter
+	if in.Kind() == reflect.Struct {
+		params = sr.inputParameters(in, route.method)
+		op.RequestBody = sr.requestBody(in, route.method)
+	}
+	op.Parameters = pathParameters(route.path, params)
+
+	status := options.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := &openAPIResponse{
+		Description: http.StatusText(status),
+		Content: map[string]*openAPIMediaType{
+			"application/json": {Schema: sr.schema(typed.OutputType())},
+		},
+	}
+	if len(options.templateFiles) > 0 {
+		response.Content["text/html"] = &openAPIMediaType{Schema: &openAPISchema{Type: "string"}}
+	}
+	op.Responses[strconv.Itoa(status)] = response
+	problem := map[string]*openAPIMediaType{
+		problemContentType: {Schema: sr.schema(reflect.TypeFor[Problem]())},
+	}
+	op.Responses["400"] = &openAPIResponse{Description: "The request could not be bound to the input", Content: problem}
+	op.Responses["422"] = &openAPIResponse{Description: "The input failed validation", Content: problem}
+	return op
+}
+
+// pathParameters returns params with a string parameter added for each
+// wildcard in routePath that params do not describe. Path parameters come
+// first, in the order of the path.
+func pathParameters(routePath string, params []*openAPIParameter) []*openAPIParameter {
+	var out []*openAPIParameter
+	for _, match := range wildcardPattern.FindAllStringSubmatch(routePath, -1) {
+		name := match[1]
+		i := slices.IndexFunc(params, func(param *openAPIParameter) bool {
+			return param.In == "path" && param.Name == name
+		})
+		if i == -1 {
+			out = append(out, &openAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &openAPISchema{Type: "string"},
+			})
+			continue
+		}
+		out = append(out, params[i])
+	}
+	for _, param := range params {
+		// path parameters without a wildcard are never bound
+		if param.In != "path" {
+			out = append(out, param)
+		}
+	}
+	return out
+}
+
+// hasBody returns whether Bind reads form fields from the body for method.
+// For other methods they come from the query string.
+func hasBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+func (sr *schemaRegistry) inputParameters(in reflect.Type, method string) []*openAPIParameter {
+	var params []*openAPIParameter
+	sources := []struct{ tag, in string }{
+		{"path", "path"},
+		{"query", "query"},
+		{"header", "header"},
+	}
+	if !hasBody(method) {
+		sources = append(sources, struct{ tag, in string }{"form", "query"})
+	}
+	for _, source := range sources {
+		eachTaggedField(in, source.tag, func(field reflect.StructField, name string) {
+			if slices.ContainsFunc(params, func(param *openAPIParameter) bool {
+				return param.In == source.in && param.Name == name
+			}) {
+				return
+			}
+			schema := sr.parameterSchema(field.Type)
+			required := applyValidateTag(schema, field)
+			params = append(params, &openAPIParameter{
+				Name:     name,
+				In:       source.in,
+				Required: required || source.in == "path",
+				Schema:   schema,
+			})
+		})
+	}
+	return params
+}
+
+// requestBody describes the form and JSON bodies Bind accepts for method.
+func (sr *schemaRegistry) requestBody(in reflect.Type, method string) *openAPIRequestBody {
+	if !hasBody(method) {
+		return nil
+	}
+	content := make(map[string]*openAPIMediaType)
+	form := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	eachTaggedField(in, "form", func(field reflect.StructField, name string) {
+		schema := sr.parameterSchema(field.Type)
+		if applyValidateTag(schema, field) {
+			form.Required = append(form.Required, name)
+		}
+		form.Properties[name] = schema
+	})
+	if len(form.Properties) > 0 {
+		content["application/x-www-form-urlencoded"] = &openAPIMediaType{Schema: form}
+	}
+	body := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	sr.addJSONProperties(body, in, func(field reflect.StructField) bool {
+		// fields bound from the path, query, or headers are not in the body
+		for _, tag := range []string{"path", "query", "header"} {
+			if _, ok := field.Tag.Lookup(tag); ok {
+				return false
+			}
+		}
+		return true
+	})
+	if len(body.Properties) > 0 {
+		content["application/json"] = &openAPIMediaType{Schema: body}
+	}
+	if len(content) == 0 {
+		return nil
+	}
+	return &openAPIRequestBody{Content: content}
+}
+
+// eachTaggedField calls fn for the fields of struct type t with the given tag,
+// including the fields of embedded structs, the way Bind finds them.
+func eachTaggedField(t reflect.Type, tag string, fn func(field reflect.StructField, name string)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, ok := field.Tag.Lookup(tag)
+		if !ok {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				eachTaggedField(field.Type, tag, fn)
+			}
+			continue
+		}
+		name, _, _ = strings.Cut(name, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fn(field, name)
+	}
+}
+
+// parameterSchema returns the schema of a value parsed from text by Bind.
+func (sr *schemaRegistry) parameterSchema(t reflect.Type) *openAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return &openAPISchema{Type: "string"}
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		if t == timeType {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+		return &openAPISchema{Type: "string"}
+	}
+	if t.Kind() == reflect.Slice {
+		return &openAPISchema{Type: "array", Items: sr.parameterSchema(t.Elem())}
+	}
+	return sr.schema(t)
+}
+
+// schema returns the schema of the JSON encoding of t. Named structs are
+// added to the components and referenced.
+func (sr *schemaRegistry) schema(t reflect.Type) *openAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case t.Kind() != reflect.Struct && t.Implements(textMarshalerType):
+		return &openAPISchema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint8, reflect.Uint16:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: sr.schema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: sr.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sr.structSchema(t)
+		}
+		name, ok := sr.names[t]
+		if !ok {
+			name = sr.schemaName(t)
+			sr.names[t] = name
+			// added before the properties so that recursive types can refer to it
+			sr.schemas[name] = &openAPISchema{}
+			*sr.schemas[name] = *sr.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	// interfaces and anything else can hold any value
+	return &openAPISchema{}
+}
+
+func (sr *schemaRegistry) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	sr.addJSONProperties(schema, t, nil)
+	return schema
+}
+
+// addJSONProperties adds the fields of struct type t that encoding/json
+// encodes, and that include accepts if it is not nil, to schema.
+func (sr *schemaRegistry) addJSONProperties(schema *openAPISchema, t reflect.Type, include func(reflect.StructField) bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		embedded := field.Type
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && !hasTag && embedded.Kind() == reflect.Struct {
+			sr.addJSONProperties(schema, embedded, include)
+			continue
+		}
+		if !field.IsExported() || (include != nil && !include(field)) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := sr.schema(field.Type)
+		if applyValidateTag(property, field) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// schemaName returns a unique component name for the named type t.
+func (sr *schemaRegistry) schemaName(t reflect.Type) string {
+	name := schemaNameCleanChar.ReplaceAllString(t.Name(), "_")
+	if _, taken := sr.schemas[name]; !taken {
+		return name
+	}
+	name = path.Base(t.PkgPath()) + "." + name
+	for i := 2; ; i++ {
+		candidate := name
+		if i > 2 {
+			candidate = fmt.Sprintf("%s%d", name, i-1)
+		}
+		if _, taken := sr.schemas[candidate]; !taken {
+			return candidate
+		}
+	}
+}
+
+// applyValidateTag adds the constraints in the validate tag of field to
+// schema, and returns whether the field is required. Referenced schemas are
+// left as they are.
+func applyValidateTag(schema *openAPISchema, field reflect.StructField) bool {
+	required := false
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		ruleName, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch {
+		case ruleName == "required":
+			required = true
+		case schema.Ref != "":
+		case ruleName == "min" || ruleName == "max" || ruleName == "len":
+			applySizeRule(schema, ruleName, param)
+		case ruleName == "email":
+			schema.Format = "email"
+		case ruleName == "url":
+			schema.Format = "uri"
+		case ruleName == "oneof":
+			for _, option := range strings.Fields(param) {
+				if schema.Type == "string" {
+					schema.Enum = append(schema.Enum, option)
+				} else if n, err := strconv.ParseFloat(option, 64); err == nil {
+					schema.Enum = append(schema.Enum, n)
+				}
+			}
+		}
+	}
+	return required
+}
+
+func applySizeRule(schema *openAPISchema, ruleName string, param string) {
+	switch schema.Type {
+	case "string", "array":
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return
+		}
+		minimum, maximum := &schema.MinLength, &schema.MaxLength
+		if schema.Type == "array" {
+			minimum, maximum = &schema.MinItems, &schema.MaxItems
+		}
+		if ruleName != "max" {
+			*minimum = &n
+		}
+		if ruleName != "min" {
+			*maximum = &n
+		}
+	case "integer", "number":
+		f, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return
+		}
+		if ruleName != "max" {
+			schema.Minimum = &f
+		}
+		if ruleName != "min" {
+			schema.Maximum = &f
+		}
+	}
+}
+
+// serveOpenAPI serves the OpenAPI document at config.OpenAPIPath, or exports
+// it and exits if OpenAPIExportEnv is set.
+func (router *Router) serveOpenAPI() error {
+	exportFile := os.Getenv(OpenAPIExportEnv)
+	if config.OpenAPIPath == "" && exportFile == "" {
+		return nil
+	}
+	doc, err := router.OpenAPI()
+	if err != nil {
+		return err
+	}
+	if exportFile != "" {
+		err = os.WriteFile(exportFile, append(doc, '\n'), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Println("-- Exported OpenAPI document to " + exportFile)
+		os.Exit(0)
+	}
+	// AddRelativeChild would replace the handler of a page or an API
+	if node := router.routeTree.Tree.FindRelativeChild(config.OpenAPIPath, http.MethodGet); node != nil && node.Handler() != nil {
+		return fmt.Errorf("cannot serve the OpenAPI document at %s\n\ta route is already there", config.OpenAPIPath)
+	}
+	_, err = router.routeTree.Tree.AddRelativeChild(config.OpenAPIPath, http.MethodGet, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(doc)
+	}), nil)
+	return err
+}
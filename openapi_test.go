@@ -0,0 +1,117 @@
+package gomx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/gomxapp/gomx/config"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+type testAddress struct {
+	Street string `json:"street" validate:"required"`
+	City   string `json:"city"`
+}
+
+type testUser struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Email     string            `json:"email,omitempty"`
+	Roles     []string          `json:"roles"`
+	Address   *testAddress      `json:"address,omitempty"`
+	Friends   []testUser        `json:"friends,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	password  string
+}
+
+type testListUsersInput struct {
+	Page   int    `query:"page" validate:"min=1"`
+	Search string `form:"q"`
+	Token  string `header:"X-Token"`
+}
+
+type testCreateUserInput struct {
+	Name    string       `form:"name" json:"name" validate:"required,min=2,max=50"`
+	Email   string       `form:"email" json:"email" validate:"required,email"`
+	Plan    string       `form:"plan" json:"plan" validate:"oneof=free pro"`
+	Address *testAddress `json:"address"`
+}
+
+type testUpdateUserInput struct {
+	ID   int64  `path:"id"`
+	Name string `json:"name" validate:"max=50"`
+}
+
+func TestOpenAPIGolden(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterOnPath("/health", http.MethodGet, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	registry.RegisterOnPath("/users", http.MethodGet, Typed(func(ctx context.Context, in testListUsersInput) ([]testUser, error) {
+		return nil, nil
+	}, WithSummary("List users", "")))
+	registry.RegisterOnPath("/users", http.MethodPost, Typed(func(ctx context.Context, in testCreateUserInput) (testUser, error) {
+		return testUser{}, nil
+	}, WithStatus(http.StatusCreated), WithTemplate([]string{"user.gohtml"}, ""), WithSummary("Create a user", "Accepts a form or a JSON body.")))
+	registry.RegisterOnPath("/users/{id}", http.MethodPatch, Typed(func(ctx context.Context, in testUpdateUserInput) (*testUser, error) {
+		return nil, nil
+	}), func(handler http.Handler) http.Handler { return handler })
+	registry.RegisterOnPath("/users/{id}/avatar", http.MethodDelete, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	routes, err := registry.routes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := json.MarshalIndent(newOpenAPIDocument("Test API", "1.0.0", routes), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = append(doc, '\n')
+	golden := filepath.Join("testdata", "openapi.golden.json")
+	if *update {
+		err = os.WriteFile(golden, doc, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(doc, want) {
+		t.Errorf("OpenAPI document does not match %s (run with -update to accept):\n%s", golden, doc)
+	}
+}
+
+func TestServeOpenAPIConflict(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterOnPath("/openapi.json", http.MethodGet, textHandler("api"))
+	router := newTestRouter(t, registry, map[string]string{
+		"routes/docs/docs.gohtml": `{{define "content"}}docs{{end}}`,
+	})
+	old := config.OpenAPIPath
+	t.Cleanup(func() { config.OpenAPIPath = old })
+
+	// the document never replaces an API or a page
+	for _, path := range []string{"/openapi.json", "/docs"} {
+		config.OpenAPIPath = path
+		if err := router.serveOpenAPI(); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+	if body := serve(router, http.MethodGet, "/openapi.json", nil).Body.String(); body != "api" {
+		t.Errorf("API replaced: %s", body)
+	}
+
+	config.OpenAPIPath = "/docs/openapi.json"
+	err := router.serveOpenAPI()
+	if w := serve(router, http.MethodGet, "/docs/openapi.json", nil); err != nil || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("free path: %v %v", err, w.Header())
+	}
+}
@@ -0,0 +1,250 @@
+package gomx
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomxapp/gomx/config"
+	"log"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenBucket describes the bucket of a rate limit. It holds up to Capacity
+// tokens and gains one every Interval. Every request takes a token, and
+// requests finding the bucket empty are turned away.
+type TokenBucket struct {
+	Capacity int
+	Interval time.Duration
+}
+
+// RateLimitResult is the state of a bucket after taking a token from it.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next token, if the request was not
+	// allowed.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets of rate limits. A store shared by
+// several instances of a program, e.g. one backed by Redis, limits requests
+// across all of them.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, creating a full bucket if
+	// there is none.
+	Take(ctx context.Context, key string, bucket TokenBucket) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore keeps token buckets in memory. They are not shared
+// between instances.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	takes   int
+	// now is time.Now, except in tests.
+	now func() time.Time
+}
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket is full again, and can be forgotten.
+	full time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket), now: time.Now}
+}
+
+func (store *MemoryRateLimitStore) Take(_ context.Context, key string, bucket TokenBucket) (RateLimitResult, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := store.now()
+	capacity := float64(bucket.Capacity)
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: capacity, last: now}
+		store.buckets[key] = b
+	}
+	// refill the tokens gained since the last request
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.last))/float64(bucket.Interval))
+	b.last = now
+	var result RateLimitResult
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(bucket.Interval))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) * float64(bucket.Interval))
+	b.full = now.Add(result.Reset)
+
+	// every so often, forget the buckets that filled up again
+	store.takes++
+	if store.takes%1000 == 0 {
+		maps.DeleteFunc(store.buckets, func(_ string, b *memoryBucket) bool {
+			return !now.Before(b.full)
+		})
+	}
+	return result, nil
+}
+
+// RateLimitOptions configure RateLimit.
+type RateLimitOptions struct {
+	// Requests are allowed per Per on average, e.g. 10 per minute.
+	Requests int
+	Per      time.Duration
+	// Burst is how many requests are allowed at once. It defaults to Requests.
+	Burst int
+	// Key tells clients apart. It defaults to ClientIP. Requests for which it
+	// returns an empty string are not limited.
+	Key func(r *http.Request) string
+	// PerRoute gives every route its own limit, instead of one limit for all
+	// the routes the middleware covers.
+	PerRoute bool
+	// Name keeps the keys of limits that share a Store apart.
+	Name string
+	// Store defaults to a new MemoryRateLimitStore.
+	Store RateLimitStore
+}
+
+// RateLimit is middleware that limits how often clients make requests, with a
+// token bucket per client. Every response gets RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset, and RateLimit-Policy headers, and
+// requests over the limit get a 429 with Retry-After from ReturnError. htmx
+// requests get the ProblemTemplate fragment, so the 429 can be shown where the
+// response would have been.
+//
+// Use it on a directory with UseDir, or on a single API with WithMiddleware:
+//
+//	router.UseDir("/search", gomx.RateLimit(gomx.RateLimitOptions{Requests: 30, Per: time.Minute}))
+//
+// A store error lets the request through.
+func RateLimit(options RateLimitOptions) Middleware {
+	// a token is added every interval, which must not round down to zero
+	if options.Requests <= 0 || options.Per <= 0 || options.Per/time.Duration(options.Requests) == 0 {
+		log.Fatalln("Error creating rate limit\n\tRequests and Per must be positive, and Per at least a nanosecond per request")
+	}
+	if options.Burst <= 0 {
+		options.Burst = options.Requests
+	}
+	if options.Key == nil {
+		options.Key = ClientIP
+	}
+	if options.Store == nil {
+		options.Store = NewMemoryRateLimitStore()
+	}
+	bucket := TokenBucket{Capacity: options.Burst, Interval: options.Per / time.Duration(options.Requests)}
+	policy := fmt.Sprintf("%d;w=%d", options.Requests, int(math.Ceil(options.Per.Seconds())))
+	if options.Burst != options.Requests {
+		policy += fmt.Sprintf(";burst=%d", options.Burst)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := options.Key(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key = options.Name + "\x00" + key
+			if options.PerRoute {
+				route := r.Method + " " + r.URL.Path
+				if matched := MatchedRoute(r); matched != nil && matched.Pattern != "" {
+					route = r.Method + " " + matched.Pattern
+				}
+				key += "\x00" + route
+			}
+			result, err := options.Store.Take(r.Context(), key, bucket)
+			if err != nil {
+				log.Printf("Error limiting rate of %s %s\n\t%v\n", r.Method, r.URL.Path, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(bucket.Capacity))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			header.Set("RateLimit-Policy", policy)
+			if !result.Allowed {
+				retryAfter := ceilSeconds(result.RetryAfter)
+				header.Set("Retry-After", strconv.Itoa(retryAfter))
+				log.Printf("429 Error: %s %s\n\trate limit of %s exceeded\n", r.Method, r.URL.Path, ClientIP(r))
+				ReturnError(w, r, TooManyRequests(fmt.Sprintf("Too many requests. Try again in %d seconds.", retryAfter)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds returns d in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientIP returns the IP address of the client making r. Requests from the
+// proxies in config.TrustedProxies ("trustedProxies" in the config file) are
+// attributed to the address they forwarded in X-Forwarded-For, skipping other
+// trusted proxies along the way. The header is ignored on requests from
+// anyone else, since clients can send any value they like.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !isTrustedProxy(addr) {
+		return addr.String()
+	}
+	// the last address was added by the closest proxy, so walk back from it
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionClientID is a RateLimitOptions.Key that tells clients apart by their
+// session ID, which requires a SessionOptions.Store. Requests without a valid
+// session cookie, and sessions kept in the cookie, fall back to ClientIP, since
+// clients can get a new one with every request.
+func SessionClientID(r *http.Request) string {
+	session := SessionOf(r)
+	if session == nil {
+		return ClientIP(r)
+	}
+	if id := session.storedID(); id != "" {
+		return "session:" + id
+	}
+	return ClientIP(r)
+}
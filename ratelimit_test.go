@@ -0,0 +1,137 @@
+package gomx
+
+import (
+	"context"
+	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/htmx"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	bucket := TokenBucket{Capacity: 2, Interval: 10 * time.Second}
+	take := func() RateLimitResult {
+		result, err := store.Take(context.Background(), "client", bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if result := take(); !result.Allowed || result.Remaining != 1 || result.Reset != 10*time.Second {
+		t.Errorf("first = %+v", result)
+	}
+	take()
+	if result := take(); result.Allowed || result.RetryAfter != 10*time.Second {
+		t.Errorf("third = %+v", result)
+	}
+	now = now.Add(5 * time.Second)
+	if result := take(); result.Allowed || result.RetryAfter != 5*time.Second {
+		t.Errorf("after 5s = %+v", result)
+	}
+	now = now.Add(5 * time.Second)
+	if result := take(); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after 10s = %+v", result)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(RateLimitOptions{Requests: 2, Per: time.Minute, PerRoute: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	request := func(target string, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set(htmx.HeaderRequest, "true")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	request("/search", "192.0.2.1")
+	w := request("/search", "192.0.2.1")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("second: %d %v", w.Code, w.Header())
+	}
+	w = request("/search", "192.0.2.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || !strings.Contains(w.Body.String(), "Try again in 30 seconds") {
+		t.Errorf("third: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("htmx requests should get a fragment: %s", w.Header().Get("Content-Type"))
+	}
+	if w = request("/login", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Errorf("other route: %d", w.Code)
+	}
+	if w = request("/search", "192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("other client: %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	old := config.TrustedProxies
+	defer func() { config.TrustedProxies = old }()
+	config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.7", "10.0.0.2"}, "198.51.100.7"},
+		{"10.0.0.1:1234", []string{"garbage"}, "10.0.0.1"},
+		{"[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remote
+		r.Header["X-Forwarded-For"] = test.forwarded
+		if ip := ClientIP(r); ip != test.want {
+			t.Errorf("%s %v: ClientIP = %s, want %s", test.remote, test.forwarded, ip, test.want)
+		}
+	}
+}
+
+func TestSessionClientID(t *testing.T) {
+	for name, store := range map[string]SessionStore{"cookie": nil, "memory": NewMemorySessionStore()} {
+		handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}, Store: store})(
+			RateLimit(RateLimitOptions{Requests: 1, Per: time.Hour, Key: SessionClientID})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = SessionOf(r).Set("seen", true)
+			})))
+		// clients without a cookie, or with a fresh one every time, share their IP's limit
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+			if w.Code != want {
+				t.Errorf("%s: cookieless request %d: status = %d, want %d", name, i, w.Code, want)
+			}
+		}
+	}
+
+	store := NewMemorySessionStore()
+	handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}, Store: store})(
+		RateLimit(RateLimitOptions{Requests: 1, Per: time.Hour, Key: SessionClientID})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	setup := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}, Store: store})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = SessionOf(r).Set("seen", true)
+	}))
+	// clients with their own sessions get their own limit, even behind one IP
+	for i := 0; i < 2; i++ {
+		cookie := visit(t, setup, nil, "/")
+		for j, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/login", nil)
+			r.AddCookie(cookie)
+			handler.ServeHTTP(w, r)
+			if w.Code != want {
+				t.Errorf("session %d, request %d: status = %d, want %d", i, j, w.Code, want)
+			}
+		}
+	}
+}
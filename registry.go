@@ -0,0 +1,158 @@
+package gomx
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/internal"
+	"log"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Registry holds the APIs registered for a router. Each Router adds the APIs of
+// its registry when it is initialized, so routers with separate registries are
+// independent of each other.
+//
+// The package-level Register functions use DefaultRegistry, which is what
+// DefaultRouter serves. This keeps the style of registering APIs in an init
+// function working, while tests and apps with several routers can create their
+// own registries with NewRegistry.
+type Registry struct {
+	mu            sync.Mutex
+	registerFuncs []ApiRegisterFunc
+}
+
+// DefaultRegistry is the registry used by the package-level Register functions
+// and by DefaultRouter.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register accepts an ApiRegisterFunc that returns the new API path, method, and handler.
+// The function is called during router Init with that router instance.
+func (registry *Registry) Register(registerFunc ApiRegisterFunc) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.registerFuncs = append(registry.registerFuncs, registerFunc)
+}
+
+// RegisterOnPath calls Register with an ApiRegisterFunc that simply returns
+// the given path, method, and handler. The handler is wrapped with the given
+// middleware, which only runs for this route.
+func (registry *Registry) RegisterOnPath(path string, method string, handler http.Handler, middleware ...Middleware) {
+	handler = documentHandler(Chain(handler, middleware...), handler)
+	registry.Register(func(tree *Router) (string, string, http.Handler) {
+		return path, method, handler
+	})
+}
+
+// RegisterOnFile adds a new API with a path derived from the caller's file.
+// See the package-level RegisterOnFile.
+func (registry *Registry) RegisterOnFile(method string, handler http.Handler, middleware ...Middleware) {
+	registry.registerOnFile(method, handler, middleware...)
+}
+
+// RegisterOnFileMethods adds an API for each method in handlers, with a path
+// derived from the caller's file. See the package-level RegisterOnFileMethods.
+func (registry *Registry) RegisterOnFileMethods(handlers map[string]http.Handler, middleware ...Middleware) {
+	registry.registerOnFileMethods(handlers, middleware...)
+}
+
+// registerOnFile registers an API for the file that called the exported
+// function calling registerOnFile.
+func (registry *Registry) registerOnFile(method string, handler http.Handler, middleware ...Middleware) {
+	path, fileMethod := callerAPIPath()
+	switch {
+	case method == "" && fileMethod == "":
+		log.Fatalf("No method given for the API at %s. Pass one or add it to the file name, e.g. items.post.go\n", path)
+	case method == "":
+		method = fileMethod
+	case fileMethod != "" && !strings.EqualFold(method, fileMethod):
+		log.Fatalf("Method %s does not match the method %s in the file name of the API at %s\n", method, fileMethod, path)
+	}
+	registry.RegisterOnPath(path, strings.ToUpper(method), handler, middleware...)
+}
+
+func (registry *Registry) registerOnFileMethods(handlers map[string]http.Handler, middleware ...Middleware) {
+	path, fileMethod := callerAPIPath()
+	if fileMethod != "" {
+		log.Fatalf("The API at %s registers several methods, but its file name has the method suffix %s\n", path, fileMethod)
+	}
+	for method, handler := range handlers {
+		registry.RegisterOnPath(path, strings.ToUpper(method), handler, middleware...)
+	}
+}
+
+// callerAPIPath returns the API path and method suffix of the file that called
+// an exported Register function. The call stack is expected to be: caller,
+// exported function, unexported register function, callerAPIPath.
+func callerAPIPath() (string, string) {
+	_, file, _, ok := runtime.Caller(3)
+	if !ok {
+		log.Fatalln("Failed to register an API")
+	}
+	path, method, err := internal.APIPathFromFile(config.ApiRootDir, file)
+	if err != nil {
+		log.Printf("Error when creating API for file %s\n", file)
+		log.Fatalln(err)
+	}
+	return path, method
+}
+
+// apiRoute is an API returned by an ApiRegisterFunc.
+type apiRoute struct {
+	path    string
+	method  string
+	handler http.Handler
+}
+
+// routes calls the registered functions for router and returns the APIs sorted
+// by path and method, so the result does not depend on registration order. An
+// API registered more than once is an error.
+func (registry *Registry) routes(router *Router) ([]apiRoute, error) {
+	registry.mu.Lock()
+	registerFuncs := slices.Clone(registry.registerFuncs)
+	registry.mu.Unlock()
+	routes := make([]apiRoute, 0, len(registerFuncs))
+	for _, registerFunc := range registerFuncs {
+		path, method, handler := registerFunc(router)
+		routes = append(routes, apiRoute{
+			path:    "/" + strings.Trim(path, "/"),
+			method:  strings.ToUpper(method),
+			handler: handler,
+		})
+	}
+	slices.SortStableFunc(routes, func(a, b apiRoute) int {
+		return cmp.Or(cmp.Compare(a.path, b.path), cmp.Compare(a.method, b.method))
+	})
+	for i := 1; i < len(routes); i++ {
+		if routes[i-1].path == routes[i].path && routes[i-1].method == routes[i].method {
+			return nil, fmt.Errorf("API registered more than once: %s %s", routes[i].method, routes[i].path)
+		}
+	}
+	return routes, nil
+}
+
+func (router *Router) initApi() error {
+	if router.registry == nil {
+		return nil
+	}
+	routes, err := router.registry.routes(router)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		_, err = router.routeTree.Tree.AddRelativeChild(route.path, route.method, route.handler, nil)
+		if err != nil {
+			return err
+		}
+	}
+	router.apiRoutes = routes
+	return nil
+}
@@ -0,0 +1,94 @@
+package gomx
+
+import (
+	"github.com/gomxapp/gomx/config"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// textHandler responds with body.
+func textHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	})
+}
+
+// routeNames returns the method and path of routes.
+func routeNames(routes []apiRoute) string {
+	names := make([]string, len(routes))
+	for i, route := range routes {
+		names[i] = route.method + " " + route.path
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestRegistryOrder(t *testing.T) {
+	apis := []struct {
+		path   string
+		method string
+	}{
+		{"/items/{id}", http.MethodPost},
+		{"items/", http.MethodGet},
+		{"/items/{id}", "get"},
+		{"/admin", http.MethodGet},
+	}
+	want := "GET /admin, GET /items, GET /items/{id}, POST /items/{id}"
+	forward, backward := NewRegistry(), NewRegistry()
+	for i := range apis {
+		forward.RegisterOnPath(apis[i].path, apis[i].method, textHandler(""))
+		backward.RegisterOnPath(apis[len(apis)-1-i].path, apis[len(apis)-1-i].method, textHandler(""))
+	}
+	for name, registry := range map[string]*Registry{"forward": forward, "backward": backward} {
+		routes, err := registry.routes(nil)
+		if err != nil || routeNames(routes) != want {
+			t.Errorf("%s: routes = %s, %v, want %s", name, routeNames(routes), err, want)
+		}
+	}
+
+	// paths and methods are normalized before looking for duplicates
+	forward.RegisterOnPath("/items", http.MethodGet, textHandler(""))
+	_, err := forward.routes(nil)
+	if err == nil || err.Error() != "API registered more than once: GET /items" {
+		t.Errorf("duplicate: %v", err)
+	}
+}
+
+func TestRegistryCallerPath(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	old := config.ApiRootDir
+	t.Cleanup(func() { config.ApiRootDir = old })
+	config.ApiRootDir = filepath.Dir(file)
+
+	// the path comes from this file, registry_test.go, not from registry.go
+	registry := NewRegistry()
+	registry.RegisterOnFile(http.MethodGet, textHandler(""))
+	registry.RegisterOnFileMethods(map[string]http.Handler{"post": textHandler(""), "delete": textHandler("")})
+	routes, err := registry.routes(nil)
+	want := "DELETE /registry/test, GET /registry/test, POST /registry/test"
+	if err != nil || routeNames(routes) != want {
+		t.Errorf("routes = %s, %v, want %s", routeNames(routes), err, want)
+	}
+}
+
+func TestRegistryIndependentRouters(t *testing.T) {
+	first, second := NewRegistry(), NewRegistry()
+	first.RegisterOnPath("/items", http.MethodGet, textHandler("first"))
+	second.RegisterOnPath("/items", http.MethodGet, textHandler("second"))
+	second.RegisterOnPath("/extra", http.MethodGet, textHandler("extra"))
+	firstRouter := newTestRouter(t, first, map[string]string{})
+	secondRouter := newTestRouter(t, second, map[string]string{})
+
+	if body := serve(firstRouter, http.MethodGet, "/items", nil).Body.String(); body != "first" {
+		t.Errorf("first router: %s", body)
+	}
+	if body := serve(secondRouter, http.MethodGet, "/items", nil).Body.String(); body != "second" {
+		t.Errorf("second router: %s", body)
+	}
+	if w := serve(firstRouter, http.MethodGet, "/extra", nil); w.Code != http.StatusNotFound {
+		t.Errorf("first router serves the APIs of the second: %d", w.Code)
+	}
+
+}
@@ -1,11 +1,15 @@
 package gomx
 
 import (
+	"context"
 	"fmt"
 	"github.com/gomxapp/gomx/config"
 	"github.com/gomxapp/gomx/internal"
+	"log"
 	"net/http"
 	"path"
+	"slices"
+	"strings"
 )
 
 // Router wraps the http.ServeMux. It matches routes using a RouteTree instance
@@ -18,19 +22,48 @@ type Router struct {
 	// are added to the route tree.
 	routeTree  *internal.RouteTreeWrapper
 	routeMaker internal.RouteMaker
+	// registry holds the APIs added to the route tree on Init.
+	registry *Registry
+	// apiRoutes are the APIs from registry, sorted by path and method.
+	apiRoutes []apiRoute
+	// middleware wraps every request, dirMiddleware wraps requests within
+	// a directory. See Use and UseDir.
+	middleware    []Middleware
+	dirMiddleware []dirMiddleware
+	// guards are the guard files in the routes directory.
+	guards []internal.Guard
+	// assets are the files added with AddStaticFiles, which the asset
+	// function of the router's pages looks up.
+	assets *internal.AssetRegistry
 
 	initialized bool
 }
 
-// DefaultRouter initializes and returns a Router with default settings.
+// DefaultRouter initializes and returns a Router with default settings. It
+// serves the APIs in DefaultRegistry, and its static files are the assets of
+// templates that no router parses, like API templates and fragments.
 func DefaultRouter() *Router {
-	r := &Router{
+	r := newRouter(DefaultRegistry, internal.DefaultAssets)
+	r.Init()
+	return r
+}
+
+// NewRouter returns a Router that serves the pages in config.RoutesDir and the
+// APIs in registry, which may be nil for a router without APIs. Routers with
+// different registries are independent, which is useful for tests; each also
+// has its own static files. Call Init before using the router.
+func NewRouter(registry *Registry) *Router {
+	return newRouter(registry, internal.NewAssetRegistry())
+}
+
+func newRouter(registry *Registry, assets *internal.AssetRegistry) *Router {
+	return &Router{
 		Mux:        http.NewServeMux(),
-		routeMaker: internal.FileBasedRouteMaker(),
+		routeMaker: internal.FileBasedRouteMaker(assets),
 		routeTree:  nil,
+		registry:   registry,
+		assets:     assets,
 	}
-	r.Init()
-	return r
 }
 
 // Init is required for all routers.
@@ -39,8 +72,22 @@ func (router *Router) Init() {
 	router.routeTree = &internal.RouteTreeWrapper{
 		Tree: router.routeMaker.GetRouteTree(),
 	}
-	router.initApi()
-	router.Mux.HandleFunc("/", router.routeTree.ServeNotFound)
+	router.guards = router.routeTree.Tree.Guards()
+	err := router.initApi()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = router.serveOpenAPI()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !config.DevMode {
+		err = internal.APITemplates.Preload()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	router.Mux.HandleFunc(notFoundPattern, router.serveNotFound)
 	fmt.Println(router.routeTree)
 	fmt.Println("-- Done")
 	router.initialized = true
@@ -50,19 +97,121 @@ func (router *Router) IsInitialized() bool {
 	return router.initialized
 }
 
-// AddStaticFiles Adds an http.FileServer handler
+// AddStaticFiles Adds an http.FileServer handler. A file with a precompressed
+// file.gz next to it is served from file.gz to clients that accept gzip.
+//
+// The files are also served under fingerprinted names with a hash of their
+// content, like /static/output.2f6e1b0c9a.css, which are cached for good. The
+// asset template function returns the current one. The hashes are read from
+// the asset manifest if there is one (see "gomx assets"), and computed
+// otherwise.
 //
 // dir: the directory path following config.AppRootDir
 func (router *Router) AddStaticFiles(dir string) {
+	manifest, err := internal.ReadAssetManifest()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = router.assets.AddDir(dir, manifest)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	// Static files
-	fs := http.FileServer(http.Dir(path.Join(config.AppRootDir, dir)))
+	fs := newStaticHandler(dir, http.Dir(path.Join(config.AppRootDir, dir)), router.assets)
 	router.Mux.Handle("GET /"+dir+"/", http.StripPrefix("/"+dir+"/", fs))
 }
 
+// Use appends middleware to the chain that wraps every request the router
+// handles: pages, APIs, static files, and not found pages. Middleware added
+// first runs first. MatchedRoute tells middleware which route was matched.
+func (router *Router) Use(middleware ...Middleware) {
+	router.middleware = append(router.middleware, middleware...)
+}
+
+// UseDir adds middleware for requests whose path is dir or lies within it,
+// e.g. "/admin" for the pages in routes/admin and APIs under /admin. It runs
+// after the middleware added with Use, and middleware for a parent directory
+// runs before middleware for its subdirectories.
+func (router *Router) UseDir(dir string, middleware ...Middleware) {
+	dir = "/" + strings.Trim(dir, "/")
+	for i := range router.dirMiddleware {
+		if router.dirMiddleware[i].dir == dir {
+			router.dirMiddleware[i].middleware = append(router.dirMiddleware[i].middleware, middleware...)
+			return
+		}
+	}
+	router.dirMiddleware = append(router.dirMiddleware, dirMiddleware{
+		dir:        dir,
+		middleware: middleware,
+	})
+	// parents sort before their subdirectories
+	slices.SortStableFunc(router.dirMiddleware, func(a, b dirMiddleware) int {
+		return len(a.dir) - len(b.dir)
+	})
+}
+
+// notFoundPattern is the catch-all pattern on Router.Mux
+const notFoundPattern = "/"
+
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if router.routeTree.ContainsExactMatch(r) {
-		router.routeTree.ServeClosestMatch(w, r)
-		return
+	match := router.routeTree.Match(r)
+	route := &Route{Method: r.Method}
+	var handler http.Handler
+	if match.IsExact() {
+		match.SetPathValues(r)
+		route.Pattern = match.Node.Pattern()
+		route.Kind = RouteAPI
+		if match.Node.IsPage() {
+			route.Kind = RoutePage
+		}
+		handler = match.Node
+	} else if _, pattern := router.Mux.Handler(r); pattern != notFoundPattern {
+		route.Pattern = pattern
+		route.Kind = RouteStatic
+		handler = router.Mux
+	} else {
+		route.Kind = RouteNotFound
+		handler = http.HandlerFunc(match.ServeNotFound)
+	}
+	ctx := context.WithValue(r.Context(), routeContextKey, route)
+	// the header lets template functions like flashes set cookies
+	r = r.WithContext(context.WithValue(ctx, responseHeaderContextKey, w.Header()))
+	// guards split the path like the route tree, so an escaped slash cannot
+	// move a segment past a wildcard
+	if guard := router.guard(match, r.URL.EscapedPath()); guard != nil {
+		handler = guardHandler(handler, guard)
 	}
-	router.Mux.ServeHTTP(w, r)
+	handler = conditional(router.wrap(handler, r.URL.Path))
+	if config.CompressionEnabled {
+		handler = Compress(handler)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// wrap wraps handler with the router middleware and the middleware of every
+// directory containing urlPath.
+func (router *Router) wrap(handler http.Handler, urlPath string) http.Handler {
+	for i := len(router.dirMiddleware) - 1; i >= 0; i-- {
+		if dm := &router.dirMiddleware[i]; dm.contains(urlPath) {
+			handler = Chain(handler, dm.middleware...)
+		}
+	}
+	return Chain(handler, router.middleware...)
+}
+
+// guard returns the guard of the matched page, or else the guard file of the
+// closest directory above it. Other paths get the most specific guard file
+// containing escapedPath, or nil.
+func (router *Router) guard(match internal.Match, escapedPath string) *internal.Guard {
+	if match.IsExact() && match.Node.IsPage() {
+		if guard := match.Node.PageGuard(); guard != nil {
+			return guard
+		}
+		return match.Node.DirGuard()
+	}
+	return internal.MostSpecificGuard(router.guards, escapedPath)
+}
+
+func (router *Router) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	router.routeTree.Match(r).ServeNotFound(w, r)
 }
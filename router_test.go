@@ -0,0 +1,261 @@
+package gomx
+
+import (
+	"github.com/gomxapp/gomx/config"
+	"github.com/gomxapp/gomx/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestRouter writes files, relative to the app root, into a temporary app
+// and returns an initialized router for it that serves the APIs in registry.
+func newTestRouter(t *testing.T, registry *Registry, files map[string]string) *Router {
+	t.Helper()
+	dir := filepath.ToSlash(t.TempDir())
+	old := []string{config.AppRootDir, config.ApiRootDir, config.RoutesDir, config.ReservedDir, config.BaseTemplate}
+	t.Cleanup(func() {
+		config.AppRootDir, config.ApiRootDir, config.RoutesDir, config.ReservedDir, config.BaseTemplate = old[0], old[1], old[2], old[3], old[4]
+		_ = internal.ReloadSharedTemplates()
+	})
+	config.AppRootDir = dir
+	config.ApiRootDir = dir + "/api"
+	config.RoutesDir = dir + "/routes"
+	config.ReservedDir = config.RoutesDir + "/_"
+	config.BaseTemplate = dir + "/index.gohtml"
+	if _, ok := files["index.gohtml"]; !ok {
+		files["index.gohtml"] = `{{block "content" .}}{{end}}`
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.MkdirAll(config.RoutesDir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(registry)
+	router.Init()
+	return router
+}
+
+// serve sends a request to handler and returns the recorded response.
+func serve(handler http.Handler, method string, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// recordMiddleware adds name to the X-Order header, and the matched route to
+// X-Route.
+func recordMiddleware(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", name)
+			if route := MatchedRoute(r); route != nil {
+				w.Header().Set("X-Route", route.Method+" "+route.Pattern)
+				w.Header().Set("X-Kind", []string{"not found", "page", "api", "static"}[route.Kind])
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterOnPath("/admin/items/{id}", http.MethodPost, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("item " + r.PathValue("id")))
+	}), recordMiddleware("route"))
+	router := newTestRouter(t, registry, map[string]string{
+		"routes/routes.gohtml":                `{{define "content"}}home{{end}}`,
+		"routes/admin/admin.gohtml":           `{{define "content"}}admin{{end}}`,
+		"routes/admin/users/users.gohtml":     `{{define "content"}}users{{end}}`,
+		"routes/administrators/admins.gohtml": `{{define "content"}}admins{{end}}`,
+	})
+	router.Use(recordMiddleware("first"), recordMiddleware("second"))
+	router.UseDir("/admin/users", recordMiddleware("users"))
+	router.UseDir("admin/", recordMiddleware("admin"))
+	router.Use(recordMiddleware("third"))
+
+	tests := []struct {
+		method string
+		target string
+		order  string
+		route  string
+		kind   string
+	}{
+		{http.MethodGet, "/", "first,second,third", "GET /", "page"},
+		{http.MethodGet, "/admin", "first,second,third,admin", "GET /admin", "page"},
+		// parent directories run before their subdirectories
+		{http.MethodGet, "/admin/users", "first,second,third,admin,users", "GET /admin/users", "page"},
+		// a directory does not cover paths that merely start with its name
+		{http.MethodGet, "/administrators", "first,second,third", "GET /administrators", "page"},
+		// route middleware runs last
+		{http.MethodPost, "/admin/items/4", "first,second,third,admin,route", "POST /admin/items/{id}", "api"},
+		{http.MethodGet, "/admin/nothing", "first,second,third,admin", "GET ", "not found"},
+	}
+	for _, test := range tests {
+		w := serve(router, test.method, test.target, nil)
+		if order := strings.Join(w.Header().Values("X-Order"), ","); order != test.order {
+			t.Errorf("%s %s: order = %s, want %s", test.method, test.target, order, test.order)
+		}
+		if route := w.Header().Get("X-Route"); route != test.route {
+			t.Errorf("%s %s: route = %q, want %q", test.method, test.target, route, test.route)
+		}
+		if kind := w.Header().Get("X-Kind"); kind != test.kind {
+			t.Errorf("%s %s: kind = %s, want %s", test.method, test.target, kind, test.kind)
+		}
+	}
+	if body := serve(router, http.MethodPost, "/admin/items/4", nil).Body.String(); body != "item 4" {
+		t.Errorf("body = %q", body)
+	}
+	if MatchedRoute(httptest.NewRequest(http.MethodGet, "/", nil)) != nil {
+		t.Error("requests outside a router should have no route")
+	}
+}
+
+func TestResourceTemplates(t *testing.T) {
+	router := newTestRouter(t, nil, map[string]string{
+		"routes/feed.xml.gotmpl": `<?xml version="1.0"?>` + "\n" +
+			`<rss><channel><title>{{html (.Request.URL.Query.Get "title")}}</title></channel></rss>`,
+		"routes/manifest.json.gotmpl":   `{"name": {{json (.Request.URL.Query.Get "name")}}, "display": "standalone"}`,
+		"routes/icons/badge.svg.gotmpl": `<svg xmlns="http://www.w3.org/2000/svg"><text>{{.Request.URL.Query.Get "label"}}</text></svg>`,
+	})
+	tests := []struct {
+		target      string
+		contentType string
+		body        string
+	}{
+		{"/feed.xml?title=Tom+%26+Jerry", "text/xml; charset=utf-8",
+			`<?xml version="1.0"?>` + "\n" + `<rss><channel><title>Tom &amp; Jerry</title></channel></rss>`},
+		{`/manifest.json?name=%22%3C%2Fscript%3E`, "application/json",
+			`{"name": "\"\u003c/script\u003e", "display": "standalone"}`},
+		// SVG is escaped like HTML, since browsers run its scripts
+		{"/icons/badge.svg?label=%3Cscript%3Ealert(1)%3C%2Fscript%3E", "image/svg+xml",
+			`<svg xmlns="http://www.w3.org/2000/svg"><text>&lt;script&gt;alert(1)&lt;/script&gt;</text></svg>`},
+	}
+	for _, test := range tests {
+		w := serve(router, http.MethodGet, test.target, nil)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s: %d %s", test.target, w.Code, w.Header().Get("Content-Type"))
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body = %s, want %s", test.target, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestRouterGuardEscapedPath(t *testing.T) {
+	router := newTestRouter(t, nil, map[string]string{
+		"routes/{w}/admin/_guard":       "auth: true",
+		"routes/{w}/admin/admin.gohtml": `{{define "content"}}admin{{end}}`,
+	})
+	for _, target := range []string{"/x/admin", "/x%2Fy/admin"} {
+		w := serve(router, http.MethodGet, target, http.Header{"Accept": {"application/json"}})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRouterGuardSiblings(t *testing.T) {
+	router := newTestRouter(t, nil, map[string]string{
+		"routes/admin/_guard":              "roles: admin",
+		"routes/admin/admin.gohtml":        `{{define "content"}}admin{{end}}`,
+		"routes/admin/x/x.gohtml":          `{{define "content"}}x{{end}}`,
+		"routes/{id}/_guard":               "auth: false",
+		"routes/{id}/item.gohtml":          `{{define "content"}}item{{end}}`,
+		"routes/{id}/edit/_guard":          "auth: true",
+		"routes/{id}/edit/edit.gohtml":     `{{define "content"}}edit{{end}}`,
+		"routes/{id}/public/public.gohtml": `{{define "content"}}public{{end}}`,
+	})
+	tests := []struct {
+		target string
+		status int
+	}{
+		// the guard of a page comes from the directories above it, never
+		// from a sibling wildcard directory
+		{"/admin", http.StatusUnauthorized},
+		{"/admin/x", http.StatusUnauthorized},
+		{"/7", http.StatusOK},
+		{"/7/public", http.StatusOK},
+		{"/admin/edit", http.StatusUnauthorized},
+		// unmatched paths get the most specific guard file containing them
+		{"/admin/missing", http.StatusUnauthorized},
+		{"/7/missing", http.StatusNotFound},
+		{"/7/edit/missing", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := serve(router, http.MethodGet, test.target, http.Header{"Accept": {"application/json"}})
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.target, w.Code, test.status)
+		}
+	}
+}
+
+func TestRouterReservedDir(t *testing.T) {
+	newTestRouter(t, nil, map[string]string{
+		"routes/partials/greeting.gohtml": `{{define "greeting"}}hello{{end}}`,
+		"routes/routes.gohtml":            `{{define "content"}}{{template "greeting"}} page{{end}}`,
+		"routes/404.gohtml":               `{{define "content"}}{{template "greeting"}} missing{{end}}`,
+		"routes/500.gohtml":               `{{define "content"}}{{template "greeting"}} error{{end}}`,
+		"routes/broken/broken.gohtml":     `{{define "content"}}{{.Arg.Missing}}{{end}}`,
+	})
+	// a reserved directory without an underscore is only known from the config
+	config.ReservedDir = config.RoutesDir + "/partials"
+	router := NewRouter(nil)
+	router.Init()
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/", http.StatusOK, "hello page"},
+		{"/missing", http.StatusNotFound, "hello missing"},
+		{"/broken", http.StatusInternalServerError, "hello error"},
+		{"/partials", http.StatusNotFound, "hello missing"},
+		{"/partials/greeting", http.StatusNotFound, "hello missing"},
+	}
+	for _, test := range tests {
+		w := serve(router, http.MethodGet, test.target, nil)
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("%s: %d %s, want %d %s", test.target, w.Code, w.Body.String(), test.status, test.body)
+		}
+	}
+}
+
+func TestRouterAssets(t *testing.T) {
+	first := newTestRouter(t, nil, map[string]string{
+		"static/app.css":       "body{}",
+		"routes/routes.gohtml": `{{define "content"}}{{asset "app.css"}}{{end}}`,
+	})
+	first.AddStaticFiles("static")
+	second := newTestRouter(t, nil, map[string]string{})
+
+	// static files, and their fingerprinted names, belong to one router
+	hashed := serve(first, http.MethodGet, "/", nil).Body.String()
+	if hashed != "/static/app.7c98040a54.css" {
+		t.Fatalf("asset = %s", hashed)
+	}
+	if w := serve(first, http.MethodGet, hashed, nil); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("first router: %d %v", w.Code, w.Header())
+	}
+	if w := serve(second, http.MethodGet, hashed, nil); w.Code != http.StatusNotFound {
+		t.Errorf("second router serves the assets of the first: %d", w.Code)
+	}
+}
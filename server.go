@@ -1,15 +1,20 @@
 package gomx
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gomxapp/gomx/config"
+	"net"
 	"net/http"
 )
 
 type Server struct {
 	s *http.Server
 	r *Router
+	// stop cancels the context returned by stopContext, which ends
+	// long-running handlers like event streams.
+	stop context.CancelFunc
 }
 
 func init() {
@@ -17,15 +22,41 @@ func init() {
 }
 
 // NewServer initializes a new Server instance and sets server.Server.handler = server.Router.
+// It also wraps server.Server.BaseContext so that long-running handlers like
+// event streams can tell when the server stops.
 func NewServer(s *http.Server, r *Router) *Server {
+	stopped, stop := context.WithCancel(context.Background())
+	baseContext := s.BaseContext
+	s.BaseContext = func(l net.Listener) context.Context {
+		ctx := context.Background()
+		if baseContext != nil {
+			ctx = baseContext(l)
+		}
+		return context.WithValue(ctx, serverStoppedContextKey, stopped)
+	}
 	s.Handler = r
 	newServer := &Server{
-		s: s,
-		r: r,
+		s:    s,
+		r:    r,
+		stop: stop,
 	}
 	return newServer
 }
 
+// stopContext returns a context derived from the request's context that is
+// also canceled when the Server handling the request is closed or shut down.
+func stopContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+	if stopped, ok := r.Context().Value(serverStoppedContextKey).(context.Context); ok {
+		stopCancel := context.AfterFunc(stopped, cancel)
+		return ctx, func() {
+			stopCancel()
+			cancel()
+		}
+	}
+	return ctx, cancel
+}
+
 // ListenAndServe wraps http.Server.ListenAndServe. It checks if the given
 // router has been initialized.
 func (server *Server) ListenAndServe() error {
@@ -43,6 +74,16 @@ func (server *Server) ListenAndServe() error {
 	return err
 }
 
+// Close ends open event streams and closes the server.
 func (server *Server) Close() error {
+	server.stop()
 	return server.s.Close()
 }
+
+// Shutdown wraps http.Server.Shutdown. It ends open event streams first, which
+// would otherwise keep the shutdown waiting until ctx is done, and lets other
+// requests finish.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.stop()
+	return server.s.Shutdown(ctx)
+}
@@ -0,0 +1,532 @@
+package gomx
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionKeysEnv is the environment variable Sessions reads its keys from when
+// SessionOptions.Keys is empty, separated by commas.
+const SessionKeysEnv = "GOMX_SESSION_KEYS"
+
+// maxCookieSize is the size above which browsers may drop a cookie.
+const maxCookieSize = 4000
+
+// ErrSessionNotFound is returned by a SessionStore for sessions it does not
+// have, or that have expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps session data on the server. The session cookie then only
+// holds a random session ID.
+type SessionStore interface {
+	// Load returns the data saved for id, or ErrSessionNotFound.
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Save saves data for id until it expires.
+	Save(ctx context.Context, id string, data []byte, expires time.Time) error
+	// Delete deletes the data of id. Deleting a missing session is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// SessionOptions configure Sessions.
+type SessionOptions struct {
+	// CookieName defaults to "gomx_session".
+	CookieName string
+	// Keys are secrets of at least 32 bytes that sign, and if Encrypt is set
+	// encrypt, the session cookie. The first key is used for new cookies and
+	// the others are only checked, so keys can be rotated by adding a new one
+	// in front and removing the oldest once its cookies have expired. If
+	// empty, the keys are read from SessionKeysEnv.
+	Keys [][]byte
+	// Encrypt encrypts the cookie with AES-GCM, so clients cannot read it.
+	Encrypt bool
+	// Store keeps the session data on the server. If nil, the data is kept in
+	// the cookie, which limits it to about 4KB.
+	Store SessionStore
+	// MaxAge is how long a session lasts without being used. It defaults to
+	// 7 days.
+	MaxAge time.Duration
+	// Path defaults to "/".
+	Path   string
+	Domain string
+	// Secure cookies are only sent over HTTPS. Cookies are always secure on
+	// requests that came over TLS.
+	Secure bool
+	// SameSite defaults to http.SameSiteLaxMode.
+	SameSite http.SameSite
+}
+
+// sessionKey is a signing key and its cipher, derived from one secret.
+type sessionKey struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+type sessionManager struct {
+	options SessionOptions
+	keys    []sessionKey
+}
+
+func newSessionManager(options SessionOptions) (*sessionManager, error) {
+	if options.CookieName == "" {
+		options.CookieName = "gomx_session"
+	}
+	if options.MaxAge == 0 {
+		options.MaxAge = 7 * 24 * time.Hour
+	}
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	secrets := options.Keys
+	if len(secrets) == 0 {
+		for _, secret := range strings.Split(os.Getenv(SessionKeysEnv), ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				secrets = append(secrets, []byte(secret))
+			}
+		}
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("sessions need a key, in SessionOptions.Keys or %s", SessionKeysEnv)
+	}
+	manager := &sessionManager{options: options}
+	for i, secret := range secrets {
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("session key %d is shorter than 32 bytes", i)
+		}
+		block, err := aes.NewCipher(deriveKey(secret, "gomx session encryption"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		manager.keys = append(manager.keys, sessionKey{
+			sign: deriveKey(secret, "gomx session signing"),
+			aead: aead,
+		})
+	}
+	return manager, nil
+}
+
+// encode signs, and if enabled encrypts, data with the first key. The expiry
+// is part of the signed value, so an old cookie cannot be replayed forever.
+func (manager *sessionManager) encode(expires time.Time, data []byte) (string, error) {
+	key := manager.keys[0]
+	payload := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	payload = append(payload, data...)
+	if manager.options.Encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return "", err
+		}
+		payload = key.aead.Seal(nonce, nonce, payload, []byte(manager.options.CookieName))
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(manager.sign(key, payload)), nil
+}
+
+func (manager *sessionManager) sign(key sessionKey, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key.sign)
+	mac.Write([]byte(manager.options.CookieName))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// decode returns the data of a cookie value and whether it was made with an
+// old key and should be made again.
+func (manager *sessionManager) decode(value string) (data []byte, expires time.Time, old bool, err error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, expires, false, errors.New("malformed session cookie")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, expires, false, err
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return nil, expires, false, err
+	}
+	for i, key := range manager.keys {
+		if !hmac.Equal(mac, manager.sign(key, payload)) {
+			continue
+		}
+		if manager.options.Encrypt {
+			nonceSize := key.aead.NonceSize()
+			if len(payload) < nonceSize {
+				return nil, expires, false, errors.New("malformed session cookie")
+			}
+			payload, err = key.aead.Open(nil, payload[:nonceSize], payload[nonceSize:], []byte(manager.options.CookieName))
+			if err != nil {
+				return nil, expires, false, err
+			}
+		}
+		if len(payload) < 8 {
+			return nil, expires, false, errors.New("malformed session cookie")
+		}
+		expires = time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+		if !time.Now().Before(expires) {
+			return nil, expires, false, errors.New("session cookie has expired")
+		}
+		return payload[8:], expires, i > 0, nil
+	}
+	return nil, expires, false, errors.New("invalid session cookie signature")
+}
+
+// Session holds the data of one client across requests. Values are stored as
+// JSON. Changes are saved when the response is written, so they must be made
+// before anything is written. A Session is safe for concurrent use.
+type Session struct {
+	manager *sessionManager
+	r       *http.Request
+	mu      sync.Mutex
+	loaded  bool
+	// fromCookie is whether the request had a valid session cookie.
+	fromCookie bool
+	id         string
+	// oldID is deleted from the store when a renewed session is saved.
+	oldID     string
+	values    map[string]json.RawMessage
+	changed   bool
+	destroyed bool
+	committed bool
+}
+
+// SessionOf returns the session of r, or nil if r is not handled by the
+// Sessions middleware.
+func SessionOf(r *http.Request) *Session {
+	return SessionFromContext(r.Context())
+}
+
+// SessionFromContext returns the session of the request ctx belongs to, for
+// typed handlers.
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionContextKey).(*Session)
+	return session
+}
+
+// load reads the session from the cookie and store. It must be called with
+// session.mu locked.
+func (session *Session) load() {
+	if session.loaded {
+		return
+	}
+	session.loaded = true
+	session.values = make(map[string]json.RawMessage)
+	manager := session.manager
+	cookie, err := session.r.Cookie(manager.options.CookieName)
+	if err != nil {
+		return
+	}
+	data, expires, old, err := manager.decode(cookie.Value)
+	if err != nil {
+		return
+	}
+	if store := manager.options.Store; store != nil {
+		id := string(data)
+		data, err = store.Load(session.r.Context(), id)
+		if err != nil {
+			if !errors.Is(err, ErrSessionNotFound) {
+				log.Printf("Error loading session\n\t%v\n", err)
+			}
+			return
+		}
+		session.id = id
+	}
+	err = json.Unmarshal(data, &session.values)
+	if err != nil {
+		log.Printf("Error decoding session\n\t%v\n", err)
+		session.values = make(map[string]json.RawMessage)
+		return
+	}
+	session.fromCookie = true
+	// refresh sessions that are half way to expiring, or made with an old key
+	session.changed = old || time.Until(expires) < manager.options.MaxAge/2
+}
+
+// storedID returns the ID of a session from the store that the request's cookie
+// named, or an empty string for new sessions and sessions kept in the cookie.
+func (session *Session) storedID() string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	if !session.fromCookie {
+		return ""
+	}
+	return session.id
+}
+
+// Get returns the value of key decoded into an any, or nil. Use SessionValue
+// to decode a value into its type.
+func (session *Session) Get(key string) any {
+	if session == nil {
+		return nil
+	}
+	var value any
+	raw, ok := session.raw(key)
+	if ok {
+		_ = json.Unmarshal(raw, &value)
+	}
+	return value
+}
+
+func (session *Session) raw(key string) (json.RawMessage, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	raw, ok := session.values[key]
+	return raw, ok
+}
+
+// SessionValue returns the value of key decoded into a T, and whether there was
+// a value of that type.
+//
+//	cart, _ := gomx.SessionValue[[]CartItem](gomx.SessionOf(r), "cart")
+func SessionValue[T any](session *Session, key string) (T, bool) {
+	var value T
+	if session == nil {
+		return value, false
+	}
+	raw, ok := session.raw(key)
+	if !ok {
+		return value, false
+	}
+	err := json.Unmarshal(raw, &value)
+	return value, err == nil
+}
+
+// Set sets key to value, which must be encodable as JSON.
+func (session *Session) Set(key string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	session.values[key] = raw
+	session.changed = true
+	return nil
+}
+
+// Delete deletes key.
+func (session *Session) Delete(key string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	if _, ok := session.values[key]; ok {
+		delete(session.values, key)
+		session.changed = true
+	}
+}
+
+// Clear deletes every value.
+func (session *Session) Clear() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	if len(session.values) > 0 {
+		clear(session.values)
+		session.changed = true
+	}
+}
+
+// Renew gives the session a new ID, keeping its values. Call it when a user
+// logs in, so a session ID planted before the login is of no use.
+func (session *Session) Renew() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	if session.id != "" && session.oldID == "" {
+		session.oldID = session.id
+	}
+	session.id = ""
+	session.changed = true
+}
+
+// Destroy deletes the session and its cookie, e.g. when a user logs out.
+func (session *Session) Destroy() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.load()
+	clear(session.values)
+	session.destroyed = true
+}
+
+// isCommitted returns whether the session was saved. Later changes are lost.
+func (session *Session) isCommitted() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.committed
+}
+
+// commit saves the session and sets its cookie on w, once.
+func (session *Session) commit(w http.ResponseWriter) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.committed {
+		return
+	}
+	// changes made after the header is written are lost, even if the session
+	// was not loaded yet
+	session.committed = true
+	if !session.loaded {
+		return
+	}
+	manager := session.manager
+	options := manager.options
+	ctx := session.r.Context()
+	cookie := &http.Cookie{
+		Name:     options.CookieName,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		Secure:   options.Secure || session.r.TLS != nil,
+		HttpOnly: true,
+		SameSite: options.SameSite,
+	}
+	if options.Store != nil {
+		for _, id := range []string{session.oldID, session.id} {
+			if id == "" || (id == session.id && !session.destroyed) {
+				continue
+			}
+			err := options.Store.Delete(ctx, id)
+			if err != nil {
+				log.Printf("Error deleting session\n\t%v\n", err)
+			}
+		}
+	}
+	if session.destroyed || (session.changed && len(session.values) == 0 && options.Store == nil) {
+		if session.fromCookie {
+			cookie.MaxAge = -1
+			http.SetCookie(w, cookie)
+		}
+		return
+	}
+	if !session.changed || (!session.fromCookie && len(session.values) == 0) {
+		return
+	}
+	expires := time.Now().Add(options.MaxAge)
+	data, err := json.Marshal(session.values)
+	if err != nil {
+		log.Printf("Error encoding session\n\t%v\n", err)
+		return
+	}
+	if options.Store != nil {
+		if session.id == "" {
+			session.id, err = newSessionID()
+			if err != nil {
+				log.Printf("Error creating session\n\t%v\n", err)
+				return
+			}
+		}
+		err = options.Store.Save(ctx, session.id, data, expires)
+		if err != nil {
+			log.Printf("Error saving session\n\t%v\n", err)
+			return
+		}
+		data = []byte(session.id)
+	}
+	cookie.Value, err = manager.encode(expires, data)
+	if err != nil {
+		log.Printf("Error encoding session\n\t%v\n", err)
+		return
+	}
+	if len(cookie.Value) > maxCookieSize {
+		log.Printf("Error saving session: the cookie is %d bytes, use a SessionStore for more than %d\n", len(cookie.Value), maxCookieSize)
+		return
+	}
+	cookie.MaxAge = int(options.MaxAge / time.Second)
+	cookie.Expires = expires
+	http.SetCookie(w, cookie)
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// Sessions is middleware that gives requests a Session, available from
+// SessionOf and the session template function:
+//
+//	router.Use(gomx.Sessions(gomx.SessionOptions{
+//		Store:   gomx.NewMemorySessionStore(),
+//		Encrypt: true,
+//	}))
+//
+// It stops the program if the options are invalid, e.g. without keys.
+func Sessions(options SessionOptions) Middleware {
+	manager, err := newSessionManager(options)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if SessionOf(r) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			session := &Session{manager: manager}
+			r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, session))
+			session.r = r
+			sw := &sessionWriter{ResponseWriter: w, session: session}
+			next.ServeHTTP(sw, r)
+			session.commit(w)
+		})
+	}
+}
+
+// sessionWriter commits the session before the header is written.
+type sessionWriter struct {
+	http.ResponseWriter
+	session *Session
+}
+
+func (sw *sessionWriter) WriteHeader(status int) {
+	sw.session.commit(sw.ResponseWriter)
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *sessionWriter) Write(p []byte) (int, error) {
+	sw.session.commit(sw.ResponseWriter)
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *sessionWriter) Flush() {
+	sw.session.commit(sw.ResponseWriter)
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
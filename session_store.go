@@ -0,0 +1,191 @@
+package gomx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore keeps sessions in memory. They are lost when the program
+// stops, and not shared between instances.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	saves    int
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+func (store *MemorySessionStore) Load(_ context.Context, id string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	session, ok := store.sessions[id]
+	if !ok || !time.Now().Before(session.expires) {
+		delete(store.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	return bytes.Clone(session.data), nil
+}
+
+func (store *MemorySessionStore) Save(_ context.Context, id string, data []byte, expires time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sessions[id] = memorySession{data: bytes.Clone(data), expires: expires}
+	// every so often, forget the sessions that expired without being loaded
+	store.saves++
+	if store.saves%1000 == 0 {
+		now := time.Now()
+		maps.DeleteFunc(store.sessions, func(_ string, session memorySession) bool {
+			return !now.Before(session.expires)
+		})
+	}
+	return nil
+}
+
+func (store *MemorySessionStore) Delete(_ context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.sessions, id)
+	return nil
+}
+
+// FileSessionStore keeps each session in a file in a directory, so sessions
+// survive restarts. Expired sessions are deleted when they are loaded, or by
+// DeleteExpired.
+type FileSessionStore struct {
+	dir string
+}
+
+// NewFileSessionStore returns a store that keeps sessions in dir, creating it
+// if needed.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// file returns the path of the file for id. IDs come from signed cookies, but
+// are checked anyway so they cannot name a file outside the directory.
+func (store *FileSessionStore) file(id string) (string, error) {
+	if id == "" || strings.ContainsFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) {
+		return "", fmt.Errorf("invalid session ID %q", id)
+	}
+	return filepath.Join(store.dir, id+".session"), nil
+}
+
+// Session files hold the expiry as Unix seconds on the first line, then the
+// data.
+func (store *FileSessionStore) Load(_ context.Context, id string) ([]byte, error) {
+	file, err := store.file(id)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	expires, data, ok := parseSessionFile(content)
+	if !ok || !time.Now().Before(expires) {
+		_ = os.Remove(file)
+		return nil, ErrSessionNotFound
+	}
+	return data, nil
+}
+
+func parseSessionFile(content []byte) (time.Time, []byte, bool) {
+	line, data, ok := bytes.Cut(content, []byte("\n"))
+	if !ok {
+		return time.Time{}, nil, false
+	}
+	seconds, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return time.Time{}, nil, false
+	}
+	return time.Unix(seconds, 0), data, true
+}
+
+func (store *FileSessionStore) Save(_ context.Context, id string, data []byte, expires time.Time) error {
+	file, err := store.file(id)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it, so a crash cannot leave half
+	// a session behind
+	tmp, err := os.CreateTemp(store.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	content := strconv.AppendInt(nil, expires.Unix(), 10)
+	content = append(content, '\n')
+	content = append(content, data...)
+	_, err = tmp.Write(content)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (store *FileSessionStore) Delete(_ context.Context, id string) error {
+	file, err := store.file(id)
+	if err != nil {
+		return nil
+	}
+	err = os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired deletes the files of expired sessions. Call it periodically
+// if many sessions are abandoned rather than loaded again.
+func (store *FileSessionStore) DeleteExpired() error {
+	files, err := filepath.Glob(filepath.Join(store.dir, "*.session"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		expires, _, ok := parseSessionFile(content)
+		if !ok || !now.Before(expires) {
+			err = os.Remove(file)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package gomx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/gomxapp/gomx/internal"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSessionKey    = []byte("0123456789abcdef0123456789abcdef")
+	testSessionKeyOld = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// sessionHandler counts visits in the session.
+func sessionHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := SessionOf(r)
+		visits, _ := SessionValue[int](session, "visits")
+		err := session.Set("visits", visits+1)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.URL.Query().Has("logout") {
+			session.Destroy()
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// visit requests handler with cookie, if any, and returns the session cookie
+// of the response.
+func visit(t *testing.T, handler http.Handler, cookie *http.Cookie, target string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	handler.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "gomx_session" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSessionsCookie(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}, Encrypt: encrypt})(sessionHandler(t))
+		cookie := visit(t, handler, nil, "/")
+		if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != 7*24*60*60 {
+			t.Fatalf("encrypt %t: cookie = %v", encrypt, cookie)
+		}
+		payload, _, _ := strings.Cut(cookie.Value, ".")
+		decoded, _ := base64.RawURLEncoding.DecodeString(payload)
+		if readable := strings.Contains(string(decoded), `{"visits":1}`); readable == encrypt {
+			t.Errorf("encrypt %t: cookie = %s", encrypt, cookie.Value)
+		}
+		cookie = visit(t, handler, cookie, "/")
+		manager, _ := newSessionManager(SessionOptions{Keys: [][]byte{testSessionKey}, Encrypt: encrypt})
+		data, _, _, err := manager.decode(cookie.Value)
+		if err != nil || string(data) != `{"visits":2}` {
+			t.Errorf("encrypt %t: session = %s, %v", encrypt, data, err)
+		}
+
+		tampered := *cookie
+		replacement := "A"
+		if cookie.Value[12] == 'A' {
+			replacement = "B"
+		}
+		tampered.Value = cookie.Value[:12] + replacement + cookie.Value[13:]
+		cookie = visit(t, handler, &tampered, "/")
+		data, _, _, _ = manager.decode(cookie.Value)
+		if string(data) != `{"visits":1}` {
+			t.Errorf("encrypt %t: tampered cookie was accepted: %s", encrypt, data)
+		}
+
+		cookie = visit(t, handler, cookie, "/?logout")
+		if cookie == nil || cookie.MaxAge != -1 {
+			t.Errorf("encrypt %t: logout cookie = %v", encrypt, cookie)
+		}
+	}
+}
+
+func TestSessionsKeyRotation(t *testing.T) {
+	oldHandler := Sessions(SessionOptions{Keys: [][]byte{testSessionKeyOld}})(sessionHandler(t))
+	cookie := visit(t, oldHandler, nil, "/")
+
+	handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey, testSessionKeyOld}})(sessionHandler(t))
+	cookie = visit(t, handler, cookie, "/")
+	manager, _ := newSessionManager(SessionOptions{Keys: [][]byte{testSessionKey}})
+	data, _, _, err := manager.decode(cookie.Value)
+	if err != nil || string(data) != `{"visits":2}` {
+		t.Errorf("session = %s, %v", data, err)
+	}
+
+	_, err = newSessionManager(SessionOptions{Keys: [][]byte{[]byte("short")}})
+	if err == nil {
+		t.Error("short keys should be rejected")
+	}
+}
+
+func TestSessionStores(t *testing.T) {
+	fileStore, err := NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]SessionStore{"memory": NewMemorySessionStore(), "file": fileStore} {
+		handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}, Store: store})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := SessionOf(r)
+			visits, _ := SessionValue[int](session, "visits")
+			_ = session.Set("visits", visits+1)
+			if r.URL.Query().Has("login") {
+				session.Renew()
+			}
+		}))
+		first := visit(t, handler, nil, "/")
+		second := visit(t, handler, first, "/?login")
+		if second == nil || second.Value == first.Value {
+			t.Fatalf("%s: renewed cookie = %v", name, second)
+		}
+		// the session of the old ID is gone
+		if cookie := visit(t, handler, first, "/"); cookie == nil {
+			t.Fatalf("%s: no cookie", name)
+		}
+		manager, _ := newSessionManager(SessionOptions{Keys: [][]byte{testSessionKey}})
+		oldID, _, _, _ := manager.decode(first.Value)
+		_, err = store.Load(context.Background(), string(oldID))
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("%s: old session was not deleted: %v", name, err)
+		}
+		id, _, _, _ := manager.decode(second.Value)
+		data, err := store.Load(context.Background(), string(id))
+		if err != nil || string(data) != `{"visits":2}` {
+			t.Errorf("%s: session = %s, %v", name, data, err)
+		}
+
+		err = store.Save(context.Background(), "expired", []byte("{}"), time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Load(context.Background(), "expired")
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("%s: expired session was loaded: %v", name, err)
+		}
+	}
+	_, err = fileStore.Load(context.Background(), "../../etc/passwd")
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("file store loaded a path: %v", err)
+	}
+}
+
+func TestSessionTemplateFunc(t *testing.T) {
+	templ := template.Must(template.New("page").Funcs(template.FuncMap{"session": templateSession}).Parse(
+		`{{with session .}}{{.Get "name"}}{{else}}none{{end}}`))
+	handler := Sessions(SessionOptions{Keys: [][]byte{testSessionKey}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = SessionOf(r).Set("name", "Ada")
+		err := templ.Execute(w, internal.PageData{Request: r})
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Body.String() != "Ada" {
+		t.Errorf("body = %q", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	_ = templ.Execute(w, internal.PageData{Request: httptest.NewRequest(http.MethodGet, "/", nil)})
+	if w.Body.String() != "none" {
+		t.Errorf("without sessions: body = %q", w.Body.String())
+	}
+}
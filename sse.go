@@ -0,0 +1,428 @@
+package gomx
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"github.com/gomxapp/gomx/internal"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEHeartbeat is how often an SSEHandler sends a comment to its stream, which
+// keeps proxies from closing idle connections.
+var SSEHeartbeat = 15 * time.Second
+
+// Event is a server-sent event.
+type Event struct {
+	// ID is sent back by the browser in the Last-Event-ID header when it
+	// reconnects. Events published on a Hub get an ID if they have none.
+	ID string
+	// Name is the event type, which is what sse-swap selects in the htmx SSE
+	// extension. Browsers use "message" if it is empty.
+	Name string
+	// Data may span several lines.
+	Data string
+	// Retry tells the browser how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+func (event *Event) writeTo(w io.Writer) error {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + stripNewlines(event.ID) + "\n")
+	}
+	if event.Name != "" {
+		b.WriteString("event: " + stripNewlines(event.Name) + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// SSEStream sends events to one client. It is safe for concurrent use.
+type SSEStream struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	r          *http.Request
+	ctx        context.Context
+	// closed is set when the handler returns, after which w must not be used.
+	closed bool
+}
+
+// errStreamClosed is returned by Send once the SSEHandler has returned.
+var errStreamClosed = errors.New("event stream is closed")
+
+// Request returns the request that opened the stream.
+func (stream *SSEStream) Request() *http.Request {
+	return stream.r
+}
+
+// Context is canceled when the client disconnects, the server is closed or
+// shut down, or the handler returns.
+func (stream *SSEStream) Context() context.Context {
+	return stream.ctx
+}
+
+// LastEventID returns the ID of the last event the client received before it
+// reconnected, or an empty string for a new connection.
+func (stream *SSEStream) LastEventID() string {
+	return stream.r.Header.Get("Last-Event-ID")
+}
+
+// Send writes event to the client and flushes it. It returns an error once
+// the SSEHandler has returned.
+func (stream *SSEStream) Send(event Event) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.closed {
+		return errStreamClosed
+	}
+	err := event.writeTo(stream.w)
+	if err != nil {
+		return err
+	}
+	return stream.controller.Flush()
+}
+
+// SendFragment renders fragment and sends it as an event called name. The
+// Swap of the fragment is ignored.
+func (stream *SSEStream) SendFragment(name string, fragment Fragment) error {
+	data, err := renderFragmentString(&fragment)
+	if err != nil {
+		return err
+	}
+	return stream.Send(Event{Name: name, Data: data})
+}
+
+func (stream *SSEStream) heartbeat() error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.closed {
+		return errStreamClosed
+	}
+	_, err := io.WriteString(stream.w, ": heartbeat\n\n")
+	if err != nil {
+		return err
+	}
+	return stream.controller.Flush()
+}
+
+func renderFragmentString(fragment *Fragment) (string, error) {
+	buf := internal.GetBuffer()
+	defer internal.PutBuffer(buf)
+	err := fragment.render(buf)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SSEHandler serves a stream of server-sent events. It can be registered like
+// any other handler, e.g. for the htmx SSE extension:
+//
+//	gomx.RegisterOnPath("/clock", http.MethodGet, gomx.SSEHandler(func(stream *gomx.SSEStream) error {
+//		ticker := time.NewTicker(time.Second)
+//		defer ticker.Stop()
+//		for {
+//			select {
+//			case <-stream.Context().Done():
+//				return nil
+//			case now := <-ticker.C:
+//				err := stream.Send(gomx.Event{Name: "tick", Data: now.Format(time.TimeOnly)})
+//				if err != nil {
+//					return err
+//				}
+//			}
+//		}
+//	}))
+//
+// The stream stays open until the function returns. A heartbeat comment is
+// sent every SSEHeartbeat meanwhile. The function should return once the
+// stream's context is done, which happens when the client disconnects or when
+// Server.Close or Server.Shutdown is called.
+type SSEHandler func(stream *SSEStream) error
+
+func (handler SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := stopContext(r)
+	defer cancel()
+	stream := &SSEStream{
+		w:          w,
+		controller: http.NewResponseController(w),
+		r:          r,
+		ctx:        ctx,
+	}
+	// the server's write timeout would end the stream
+	err := stream.controller.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error starting event stream %s\n\t%v\n", r.URL.Path, err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	err = stream.controller.Flush()
+	if err != nil {
+		log.Printf("Error starting event stream %s\n\t%v\n", r.URL.Path, err)
+		return
+	}
+
+	var heartbeats sync.WaitGroup
+	heartbeats.Add(1)
+	defer func() {
+		cancel()
+		heartbeats.Wait()
+		// goroutines the function started may still hold the stream
+		stream.mu.Lock()
+		stream.closed = true
+		stream.mu.Unlock()
+	}()
+	go func() {
+		defer heartbeats.Done()
+		ticker := time.NewTicker(SSEHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if stream.heartbeat() != nil {
+					return
+				}
+			}
+		}
+	}()
+	err = handler(stream)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Error in event stream %s\n\t%v\n", r.URL.Path, err)
+	}
+}
+
+// ErrSlowSubscriber ends a Hub subscription whose client does not keep up
+// with the published events. The client reconnects and catches up from the
+// replay buffer.
+var ErrSlowSubscriber = errors.New("subscriber is too slow")
+
+// subscriberBuffer is how many events may be queued for a subscriber.
+const subscriberBuffer = 64
+
+// Hub broadcasts events to the SSE streams subscribed to a topic. It keeps
+// the latest events of each topic, so that clients reconnecting with a
+// Last-Event-ID get the events they missed.
+type Hub struct {
+	mu          sync.Mutex
+	replay      int
+	lastID      uint64
+	topics      map[string]*hubTopic
+	subscribers map[*hubSubscriber]struct{}
+	closed      bool
+}
+
+type hubTopic struct {
+	// history is a ring buffer of the latest events, next is where the next
+	// event goes.
+	history []hubEvent
+	next    int
+}
+
+type hubEvent struct {
+	id    uint64
+	event Event
+}
+
+type hubSubscriber struct {
+	topics []string
+	events chan Event
+	err    error
+}
+
+// NewHub returns a Hub that keeps the last replay events of each topic for
+// reconnecting clients.
+func NewHub(replay int) *Hub {
+	return &Hub{
+		replay:      replay,
+		topics:      make(map[string]*hubTopic),
+		subscribers: make(map[*hubSubscriber]struct{}),
+	}
+}
+
+// Publish sends event to the subscribers of topic. Events without an ID get
+// the next number of the hub; only numbered events can be replayed.
+func (hub *Hub) Publish(topic string, event Event) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		return
+	}
+	var id uint64
+	if event.ID == "" {
+		hub.lastID++
+		id = hub.lastID
+		event.ID = strconv.FormatUint(id, 10)
+	}
+	if hub.replay > 0 && id != 0 {
+		t, ok := hub.topics[topic]
+		if !ok {
+			t = &hubTopic{}
+			hub.topics[topic] = t
+		}
+		if len(t.history) < hub.replay {
+			t.history = append(t.history, hubEvent{id: id, event: event})
+		} else {
+			t.history[t.next] = hubEvent{id: id, event: event}
+		}
+		t.next = (t.next + 1) % hub.replay
+	}
+	for sub := range hub.subscribers {
+		if !sub.subscribed(topic) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.err = ErrSlowSubscriber
+			hub.removeLocked(sub)
+		}
+	}
+}
+
+// PublishFragment renders fragment and publishes it to topic as an event
+// called name, e.g. the sse-swap name in the htmx SSE extension. The fragment
+// is rendered once for all subscribers.
+func (hub *Hub) PublishFragment(topic string, name string, fragment Fragment) error {
+	data, err := renderFragmentString(&fragment)
+	if err != nil {
+		return err
+	}
+	hub.Publish(topic, Event{Name: name, Data: data})
+	return nil
+}
+
+// Subscribe sends the events published to topics to stream until the stream's
+// context is done or the hub is closed. If the client reconnected, the events
+// it missed are sent first.
+func (hub *Hub) Subscribe(stream *SSEStream, topics ...string) error {
+	sub := &hubSubscriber{
+		topics: topics,
+		events: make(chan Event, subscriberBuffer),
+	}
+	hub.mu.Lock()
+	if hub.closed {
+		hub.mu.Unlock()
+		return nil
+	}
+	missed := hub.missedLocked(stream.LastEventID(), topics)
+	hub.subscribers[sub] = struct{}{}
+	hub.mu.Unlock()
+	defer func() {
+		hub.mu.Lock()
+		hub.removeLocked(sub)
+		hub.mu.Unlock()
+	}()
+
+	for _, event := range missed {
+		err := stream.Send(event)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-sub.events:
+			if !ok {
+				hub.mu.Lock()
+				err := sub.err
+				hub.mu.Unlock()
+				return err
+			}
+			err := stream.Send(event)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Handler returns an SSEHandler that subscribes each stream to topics.
+func (hub *Hub) Handler(topics ...string) SSEHandler {
+	return func(stream *SSEStream) error {
+		return hub.Subscribe(stream, topics...)
+	}
+}
+
+// Close ends all subscriptions. Events published afterward are dropped.
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.closed = true
+	for sub := range hub.subscribers {
+		hub.removeLocked(sub)
+	}
+}
+
+func (hub *Hub) removeLocked(sub *hubSubscriber) {
+	if _, ok := hub.subscribers[sub]; ok {
+		delete(hub.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// missedLocked returns the kept events of topics published after lastEventID,
+// in the order they were published.
+func (hub *Hub) missedLocked(lastEventID string, topics []string) []Event {
+	if lastEventID == "" {
+		return nil
+	}
+	lastID, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	var missed []hubEvent
+	for _, topic := range topics {
+		t, ok := hub.topics[topic]
+		if !ok {
+			continue
+		}
+		for i := range t.history {
+			// oldest first
+			e := t.history[(t.next+i)%len(t.history)]
+			if e.id > lastID {
+				missed = append(missed, e)
+			}
+		}
+	}
+	// merge the topics by ID
+	slices.SortFunc(missed, func(a, b hubEvent) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	events := make([]Event, len(missed))
+	for i, e := range missed {
+		events[i] = e.event
+	}
+	return events
+}
+
+func (sub *hubSubscriber) subscribed(topic string) bool {
+	return slices.Contains(sub.topics, topic)
+}
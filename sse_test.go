@@ -0,0 +1,137 @@
+package gomx
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readEvent reads the lines of the next event, skipping heartbeats.
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(lines) > 0 {
+			return lines
+		}
+		if line != "" && !strings.HasPrefix(line, ":") {
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestEventFormat(t *testing.T) {
+	var b strings.Builder
+	event := Event{ID: "7", Name: "item", Data: "<li>\r\n  a\n</li>", Retry: 2 * time.Second}
+	err := event.writeTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id: 7\nevent: item\nretry: 2000\ndata: <li>\ndata:   a\ndata: </li>\n\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestHubReplayAndShutdown(t *testing.T) {
+	hub := NewHub(2)
+	for _, data := range []string{"a", "b", "c"} {
+		hub.Publish("news", Event{Data: data})
+	}
+	hub.Publish("other", Event{Data: "not subscribed"})
+
+	ts := httptest.NewUnstartedServer(nil)
+	server := NewServer(ts.Config, nil)
+	ts.Config.Handler = hub.Handler("news")
+	ts.Start()
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "a" was pushed out of the replay buffer, so only "c" is missed
+	req.Header.Set("Last-Event-ID", "2")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+	reader := bufio.NewReader(res.Body)
+	if got := readEvent(t, reader); strings.Join(got, "|") != "id: 3|data: c" {
+		t.Errorf("replayed %v", got)
+	}
+	hub.Publish("news", Event{Name: "update", Data: "d"})
+	if got := readEvent(t, reader); strings.Join(got, "|") != "id: 5|event: update|data: d" {
+		t.Errorf("received %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	_, err = io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("stream did not end cleanly: %v", err)
+	}
+}
+
+// lateWriter records writes made after done is set.
+type lateWriter struct {
+	httptest.ResponseRecorder
+	mu   sync.Mutex
+	done bool
+	late bool
+}
+
+func (lw *lateWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.late = lw.late || lw.done
+	return len(p), nil
+}
+
+func (lw *lateWriter) Flush() {}
+
+func TestSSEHandlerStopsWritingOnReturn(t *testing.T) {
+	old := SSEHeartbeat
+	SSEHeartbeat = time.Millisecond
+	defer func() { SSEHeartbeat = old }()
+
+	var leaked *SSEStream
+	handler := SSEHandler(func(stream *SSEStream) error {
+		leaked = stream
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	w := &lateWriter{ResponseRecorder: *httptest.NewRecorder()}
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	w.mu.Lock()
+	w.done = true
+	w.mu.Unlock()
+	if err := leaked.Send(Event{Data: "late"}); err == nil {
+		t.Error("Send after the handler returned should fail")
+	}
+	time.Sleep(5 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.late {
+		t.Error("the stream was written to after the handler returned")
+	}
+}
@@ -0,0 +1,205 @@
+package gomx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+type contextKey int
+
+const (
+	requestContextKey contextKey = iota
+	responseWriterContextKey
+	routeContextKey
+	serverStoppedContextKey
+	sessionContextKey
+	csrfContextKey
+	responseHeaderContextKey
+	authContextKey
+)
+
+// RequestFromContext returns the request being handled by a typed handler.
+func RequestFromContext(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestContextKey).(*http.Request)
+	return r
+}
+
+// ResponseWriterFromContext returns the response writer of a typed handler, for
+// setting headers or cookies before the output is written.
+func ResponseWriterFromContext(ctx context.Context) http.ResponseWriter {
+	w, _ := ctx.Value(responseWriterContextKey).(http.ResponseWriter)
+	return w
+}
+
+// Validator is implemented by typed handler inputs that check themselves after
+// being bound. A non-nil error results in a 422 response.
+type Validator interface {
+	Validate() error
+}
+
+// TypedOption configures a typed handler.
+type TypedOption func(*typedOptions)
+
+type typedOptions struct {
+	templateFiles []string
+	templateName  string
+	formFiles     []string
+	formName      string
+	status        int
+	middleware    []Middleware
+	summary       string
+	description   string
+}
+
+// WithTemplate renders the output of a typed handler with the template called
+// name from files (see ReturnGoHTMLFromFiles) when the request asks for HTML,
+// like htmx requests do. Other requests get JSON, or plain text if they ask for
+// it and the output is a string or fmt.Stringer (see ReturnNegotiated).
+func WithTemplate(files []string, name string) TypedOption {
+	return func(options *typedOptions) {
+		options.templateFiles = files
+		options.templateName = name
+	}
+}
+
+// WithForm re-renders the submitted form with ReturnFormErrors when the input
+// of an HTML request fails validation. The form template gets FormData with
+// the bound input as its Values.
+func WithForm(files []string, name string) TypedOption {
+	return func(options *typedOptions) {
+		options.formFiles = files
+		options.formName = name
+	}
+}
+
+// WithMiddleware wraps a typed handler in middleware that only runs for it.
+func WithMiddleware(middleware ...Middleware) TypedOption {
+	return func(options *typedOptions) {
+		options.middleware = append(options.middleware, middleware...)
+	}
+}
+
+// WithStatus sets the status code of successful responses. The default is 200.
+func WithStatus(status int) TypedOption {
+	return func(options *typedOptions) {
+		options.status = status
+	}
+}
+
+// WithSummary sets the summary and description of a typed handler's operation
+// in the OpenAPI document. The description may be empty.
+func WithSummary(summary string, description string) TypedOption {
+	return func(options *typedOptions) {
+		options.summary = summary
+		options.description = description
+	}
+}
+
+type typedHandler[In any, Out any] struct {
+	fn      func(context.Context, In) (Out, error)
+	options typedOptions
+	// handler serves requests through the middleware given with WithMiddleware.
+	handler http.Handler
+}
+
+// Typed returns a handler that binds each request into an In value (see Bind),
+// validates it, calls fn, and renders the returned Out as JSON or through the
+// template given with WithTemplate.
+//
+// Inputs are validated with Validate. Requests that cannot be bound get a 400
+// response, inputs that fail validation get a 422 response (or the form given
+// with WithForm), and errors returned by fn are answered with ReturnError, so
+// fn can return an HTTPError for a specific status.
+func Typed[In any, Out any](fn func(context.Context, In) (Out, error), options ...TypedOption) http.Handler {
+	handler := &typedHandler[In, Out]{
+		fn: fn,
+	}
+	for _, option := range options {
+		option(&handler.options)
+	}
+	handler.handler = Chain(http.HandlerFunc(handler.serve), handler.options.middleware...)
+	return handler
+}
+
+// Handle registers a typed handler (see Typed) on the given path and method in
+// DefaultRegistry. To register one in another registry, use
+// registry.RegisterOnPath(path, method, Typed(fn)).
+//
+// Example:
+//
+//	type getItemInput struct {
+//		ID int `path:"id"`
+//	}
+//
+//	func init() {
+//		gomx.Handle("/items/{id}", http.MethodGet, func(ctx context.Context, in getItemInput) (data.Item, error) {
+//			return data.GetItem(in.ID)
+//		}, gomx.WithTemplate([]string{"item.gohtml"}, ""))
+//	}
+func Handle[In any, Out any](path string, method string, fn func(context.Context, In) (Out, error), options ...TypedOption) {
+	RegisterOnPath(path, method, Typed(fn, options...))
+}
+
+func (th *typedHandler[In, Out]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	th.handler.ServeHTTP(w, r)
+}
+
+func (th *typedHandler[In, Out]) serve(w http.ResponseWriter, r *http.Request) {
+	var in In
+	err := Bind(r, &in)
+	if err != nil {
+		ReturnError(w, r, err)
+		return
+	}
+	// invalid input is a ValidationErrors, anything else is a misconfigured
+	// validate tag and gets a 500 response
+	err = Validate(&in)
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) && len(th.options.formFiles) > 0 && wantsHTML(r) {
+		err = ReturnFormErrors(w, r, th.options.formFiles, th.options.formName, in, validationErrs)
+		if err != nil {
+			ReturnError(w, r, fmt.Errorf("rendering form: %w", err))
+		}
+		return
+	}
+	if err != nil {
+		ReturnError(w, r, err)
+		return
+	}
+	ctx := context.WithValue(r.Context(), requestContextKey, r)
+	ctx = context.WithValue(ctx, responseWriterContextKey, w)
+	out, err := th.fn(ctx, in)
+	if err != nil {
+		ReturnError(w, r, err)
+		return
+	}
+	err = ReturnNegotiated(w, r, Negotiation{
+		Status:        th.options.status,
+		Data:          out,
+		TemplateFiles: th.options.templateFiles,
+		TemplateName:  th.options.templateName,
+	})
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		ReturnError(w, r, err)
+	} else if err != nil {
+		ReturnError(w, r, fmt.Errorf("rendering output: %w", err))
+	}
+}
+
+// InputType returns the type requests are bound into.
+func (th *typedHandler[In, Out]) InputType() reflect.Type {
+	return reflect.TypeFor[In]()
+}
+
+// OutputType returns the type of the rendered output.
+func (th *typedHandler[In, Out]) OutputType() reflect.Type {
+	return reflect.TypeFor[Out]()
+}
+
+func (th *typedHandler[In, Out]) apiOptions() *typedOptions {
+	return &th.options
+}
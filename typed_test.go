@@ -0,0 +1,71 @@
+package gomx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type itemInput struct {
+	ID   int    `path:"id"`
+	Note string `query:"note" validate:"max=5"`
+}
+
+type itemOutput struct {
+	ID   int    `json:"id"`
+	Note string `json:"note"`
+}
+
+func TestTyped(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterOnPath("/items/{id}", http.MethodGet, Typed(func(ctx context.Context, in itemInput) (itemOutput, error) {
+		if in.ID == 0 {
+			return itemOutput{}, NotFound("No such item.")
+		}
+		if RequestFromContext(ctx) == nil || ResponseWriterFromContext(ctx) == nil {
+			t.Error("the context should hold the request and response writer")
+		}
+		return itemOutput{ID: in.ID, Note: in.Note}, nil
+	}, WithTemplate([]string{"item.gohtml"}, "")))
+	router := newTestRouter(t, registry, map[string]string{
+		"api/item.gohtml": `<p>item {{.ID}}: {{.Note}}</p>`,
+	})
+
+	tests := []struct {
+		name        string
+		target      string
+		header      http.Header
+		status      int
+		contentType string
+		body        string
+	}{
+		{"json", "/items/4?note=hi", nil, http.StatusOK, "application/json", `{"id":4,"note":"hi"}`},
+		{"accept json", "/items/4", http.Header{"Accept": {"application/json"}}, http.StatusOK, "application/json", `{"id":4,"note":""}`},
+		{"htmx", "/items/4?note=%3Cb%3E", http.Header{"Hx-Request": {"true"}}, http.StatusOK, "text/html; charset=utf-8", `<p>item 4: &lt;b&gt;</p>`},
+		{"browser", "/items/4", http.Header{"Accept": {"text/html,*/*;q=0.8"}}, http.StatusOK, "text/html; charset=utf-8", `<p>item 4: </p>`},
+		{"malformed path", "/items/four", nil, http.StatusBadRequest, problemContentType, ""},
+		{"invalid", "/items/4?note=too+long", nil, http.StatusUnprocessableEntity, problemContentType, ""},
+		{"handler error", "/items/0", nil, http.StatusNotFound, problemContentType, ""},
+	}
+	for _, test := range tests {
+		w := serve(router, http.MethodGet, test.target, test.header)
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s: Content-Type = %s, want %s", test.name, ct, test.contentType)
+		}
+		if test.body != "" && strings.TrimSpace(w.Body.String()) != test.body {
+			t.Errorf("%s: body = %s, want %s", test.name, w.Body.String(), test.body)
+		}
+	}
+
+	w := serve(router, http.MethodGet, "/items/four", nil)
+	var problem Problem
+	err := json.Unmarshal(w.Body.Bytes(), &problem)
+	if err != nil || problem.Detail != "The path value for ID is invalid." {
+		t.Errorf("malformed path: problem = %+v, %v", problem, err)
+	}
+}
@@ -0,0 +1,381 @@
+package gomx
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomxapp/gomx/htmx"
+	"github.com/gomxapp/gomx/internal"
+	"html"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationRule checks a field value against the rule's parameter, e.g. "3"
+// for `validate:"min=3"`. The returned error's message is shown to the user,
+// so it should read as the rest of a sentence like "must be at least 3".
+type ValidationRule func(value reflect.Value, param string) error
+
+var validationRules = struct {
+	sync.RWMutex
+	rules map[string]ValidationRule
+}{
+	rules: map[string]ValidationRule{
+		"required": validateRequired,
+		"min":      validateMin,
+		"max":      validateMax,
+		"len":      validateLen,
+		"email":    validateEmail,
+		"url":      validateURL,
+		"oneof":    validateOneOf,
+	},
+}
+
+// RegisterValidationRule adds a rule that can be used in validate struct tags.
+// Registering a rule with the name of an existing one replaces it.
+func RegisterValidationRule(name string, rule ValidationRule) {
+	validationRules.Lock()
+	defer validationRules.Unlock()
+	validationRules.rules[name] = rule
+}
+
+// ValidationErrors maps field names to the message of the first rule they
+// failed. Fields are named after their form tag, then their json tag, then the
+// struct field name, so the keys match the inputs of the submitted form.
+type ValidationErrors map[string]string
+
+func (errs ValidationErrors) Error() string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field == "" {
+			messages = append(messages, errs[field])
+		} else {
+			messages = append(messages, field+" "+errs[field])
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks the fields of the struct v points to against the rules in
+// their validate tags, for example:
+//
+//	type signupInput struct {
+//		Email string `form:"email" validate:"required,email"`
+//		Name  string `form:"name" validate:"required,min=2,max=50"`
+//		Plan  string `form:"plan" validate:"oneof=free pro"`
+//	}
+//
+// Rules are comma-separated and run in order; a field reports only its first
+// failure. Apart from required, rules are skipped for empty fields. If every
+// rule passes and v implements Validator, its Validate method is called as
+// well. Failures are returned as ValidationErrors; an error from Validate that
+// is not ValidationErrors is reported under the empty field name.
+func Validate(v any) error {
+	errs := make(ValidationErrors)
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Struct {
+		err := validateStruct(rv, errs)
+		if err != nil {
+			return err
+		}
+	}
+	if validator, ok := v.(Validator); ok && len(errs) == 0 {
+		err := validator.Validate()
+		var fieldErrs ValidationErrors
+		if errors.As(err, &fieldErrs) {
+			errs = fieldErrs
+		} else if err != nil {
+			errs[""] = err.Error()
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateStruct(rv reflect.Value, errs ValidationErrors) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				err := validateStruct(rv.Field(i), errs)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		name := fieldName(field)
+		for _, rule := range strings.Split(tag, ",") {
+			ruleName, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			if ruleName == "" {
+				continue
+			}
+			validationRules.RLock()
+			fn, ok := validationRules.rules[ruleName]
+			validationRules.RUnlock()
+			if !ok {
+				return fmt.Errorf("unknown validation rule %q on field %s", ruleName, field.Name)
+			}
+			value := rv.Field(i)
+			if ruleName != "required" {
+				if isEmptyValue(value) {
+					continue
+				}
+				for value.Kind() == reflect.Pointer {
+					value = value.Elem()
+				}
+			}
+			err := fn(value, param)
+			if err != nil {
+				errs[name] = err.Error()
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// fieldName returns the name a field is submitted under.
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"form", "json", "query", "path"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() == 0
+	}
+	return false
+}
+
+func validateRequired(value reflect.Value, _ string) error {
+	if isEmptyValue(value) || value.IsZero() {
+		return errors.New("is required")
+	}
+	return nil
+}
+
+// compareSize compares the length of strings, slices, and maps, or the value
+// of numbers, with param. It returns the comparison result and a unit for the
+// error message.
+func compareSize(value reflect.Value, param string) (int, string, error) {
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid length %q", param)
+		}
+		length := value.Len()
+		unit := " items"
+		if value.Kind() == reflect.String {
+			length = utf8.RuneCountInString(value.String())
+			unit = " characters"
+		}
+		return cmpInt(int64(length), int64(n)), unit, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid number %q", param)
+		}
+		return cmpInt(value.Int(), n), "", nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid number %q", param)
+		}
+		switch {
+		case value.Uint() < n:
+			return -1, "", nil
+		case value.Uint() > n:
+			return 1, "", nil
+		}
+		return 0, "", nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid number %q", param)
+		}
+		switch {
+		case value.Float() < f:
+			return -1, "", nil
+		case value.Float() > f:
+			return 1, "", nil
+		}
+		return 0, "", nil
+	}
+	return 0, "", fmt.Errorf("cannot compare the size of %s", value.Type())
+}
+
+func cmpInt(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func validateMin(value reflect.Value, param string) error {
+	cmp, unit, err := compareSize(value, param)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return fmt.Errorf("must be at least %s%s", param, unit)
+	}
+	return nil
+}
+
+func validateMax(value reflect.Value, param string) error {
+	cmp, unit, err := compareSize(value, param)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("must be at most %s%s", param, unit)
+	}
+	return nil
+}
+
+func validateLen(value reflect.Value, param string) error {
+	cmp, unit, err := compareSize(value, param)
+	if err != nil {
+		return err
+	}
+	if cmp != 0 {
+		return fmt.Errorf("must be exactly %s%s", param, unit)
+	}
+	return nil
+}
+
+func validateEmail(value reflect.Value, _ string) error {
+	if value.Kind() != reflect.String {
+		return fmt.Errorf("cannot check %s as an email address", value.Type())
+	}
+	address, err := mail.ParseAddress(value.String())
+	if err != nil || address.Address != value.String() {
+		return errors.New("must be a valid email address")
+	}
+	return nil
+}
+
+func validateURL(value reflect.Value, _ string) error {
+	if value.Kind() != reflect.String {
+		return fmt.Errorf("cannot check %s as a URL", value.Type())
+	}
+	u, err := url.ParseRequestURI(value.String())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be a valid URL")
+	}
+	return nil
+}
+
+func validateOneOf(value reflect.Value, param string) error {
+	options := strings.Fields(param)
+	if slices.Contains(options, fmt.Sprint(value.Interface())) {
+		return nil
+	}
+	return fmt.Errorf("must be one of: %s", strings.Join(options, ", "))
+}
+
+// FormData is passed to a form template that is re-rendered after a failed
+// submission.
+type FormData struct {
+	// Values is the submitted input, so fields keep what the user entered.
+	Values any
+	// Errors holds a message for each invalid field, e.g. {{index .Errors "email"}}.
+	Errors ValidationErrors
+}
+
+// ReturnFormErrors re-renders a form with its submitted values and validation
+// errors using the template called name from files (see ReturnGoHTMLFromFiles),
+// with FormData as the template data. The response has a 422 status.
+//
+// For htmx requests that target the form itself, i.e. HX-Target is the id of
+// the form element the template renders, HX-Retarget is set to the form and
+// HX-Reswap to outerHTML, so the form replaces itself instead of being nested
+// inside the old one. A form with
+// hx-post targets itself by default; a submit button with hx-post needs
+// hx-target pointing at the form. Other targets are left to htmx. Note that
+// htmx does not swap 4xx responses by default; allow 422 in
+// htmx.config.responseHandling (htmx 2) or in an htmx:beforeSwap listener.
+func ReturnFormErrors(w http.ResponseWriter, r *http.Request, files []string, name string, values any, errs ValidationErrors) error {
+	t, err := internal.APITemplates.Files(apiFilePaths(files), name)
+	if err != nil {
+		return err
+	}
+	buf := internal.GetBuffer()
+	defer internal.PutBuffer(buf)
+	err = t.Execute(buf, FormData{Values: values, Errors: errs})
+	if err != nil {
+		return err
+	}
+	if htmx.IsRequest(r) {
+		if id := htmx.Target(r); id != "" && id == formID(buf.String()) {
+			htmx.Retarget(w, "#"+cssEscapeID(id))
+			htmx.Reswap(w, htmx.SwapOuterHTML)
+		}
+	}
+	return internal.WriteBuffer(w, http.StatusUnprocessableEntity, htmlContentType, buf)
+}
+
+var formIDPattern = regexp.MustCompile(`^\s*<form\b[^>]*?\sid="([^"]*)"`)
+
+// formID returns the id of the form element that markup starts with, or "".
+func formID(markup string) string {
+	match := formIDPattern.FindStringSubmatch(markup)
+	if match == nil {
+		return ""
+	}
+	return html.UnescapeString(match[1])
+}
+
+// cssEscapeID escapes id for use in an id selector, like CSS.escape.
+func cssEscapeID(id string) string {
+	var b strings.Builder
+	for i, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c >= 0x80,
+			c == '-' && !(i == 0 && len(id) == 1),
+			c >= '0' && c <= '9' && i > 0 && !(i == 1 && id[0] == '-'):
+			b.WriteRune(c)
+		default:
+			fmt.Fprintf(&b, "\\%x ", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package gomx
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidationRules(t *testing.T) {
+	tests := []struct {
+		rule  string
+		value any
+		param string
+		want  string
+	}{
+		{"required", "ada", "", ""},
+		{"required", " ", "", "is required"},
+		{"required", 0, "", "is required"},
+		{"required", []string{}, "", "is required"},
+		{"min", "ab", "3", "must be at least 3 characters"},
+		{"min", "äöü", "3", ""},
+		{"min", []int{1}, "2", "must be at least 2 items"},
+		{"min", 4, "5", "must be at least 5"},
+		{"min", uint(5), "5", ""},
+		{"min", 2.5, "2.5", ""},
+		{"max", "abcd", "3", "must be at most 3 characters"},
+		{"max", -1, "0", ""},
+		{"max", 0.5, "0.25", "must be at most 0.25"},
+		{"len", "abc", "3", ""},
+		{"len", []string{"a"}, "2", "must be exactly 2 items"},
+		{"min", "abc", "x", `invalid length "x"`},
+		{"min", true, "1", "cannot compare the size of bool"},
+		{"email", "ada@example.com", "", ""},
+		{"email", "Ada <ada@example.com>", "", "must be a valid email address"},
+		{"email", "ada", "", "must be a valid email address"},
+		{"url", "https://example.com/a", "", ""},
+		{"url", "javascript:alert(1)", "", "must be a valid URL"},
+		{"url", "/relative", "", "must be a valid URL"},
+		{"oneof", "pro", "free pro", ""},
+		{"oneof", "gold", "free pro", "must be one of: free, pro"},
+		{"oneof", 2, "1 2 3", ""},
+	}
+	for _, test := range tests {
+		rule := validationRules.rules[test.rule]
+		err := rule(reflect.ValueOf(test.value), test.param)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%s=%s on %#v: got %q, want %q", test.rule, test.param, test.value, got, test.want)
+		}
+	}
+}
+
+type signupInput struct {
+	Email    string   `form:"email" validate:"required,email"`
+	Name     string   `json:"name" validate:"min=2,max=5"`
+	Plan     *string  `query:"plan" validate:"oneof=free pro"`
+	Tags     []string `validate:"max=2"`
+	Password string   `form:"password"`
+	Repeat   string   `form:"repeat"`
+}
+
+func (in *signupInput) Validate() error {
+	if in.Password != in.Repeat {
+		return ValidationErrors{"repeat": "must match the password"}
+	}
+	if in.Password == "password" {
+		return errors.New("pick a better password")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	gold := "gold"
+	tests := []struct {
+		name  string
+		input signupInput
+		want  ValidationErrors
+	}{
+		{"valid", signupInput{Email: "ada@example.com"}, nil},
+		// rules other than required skip empty fields, and fields are named
+		// after their tags
+		{"empty", signupInput{}, ValidationErrors{"email": "is required"}},
+		{"first failure", signupInput{Email: "ada", Name: "A", Plan: &gold, Tags: []string{"a", "b", "c"}},
+			ValidationErrors{"email": "must be a valid email address", "name": "must be at least 2 characters", "plan": "must be one of: free, pro", "Tags": "must be at most 2 items"}},
+		// Validate only runs once the rules pass
+		{"validator", signupInput{Email: "ada@example.com", Password: "a", Repeat: "b"}, ValidationErrors{"repeat": "must match the password"}},
+		{"validator error", signupInput{Email: "ada@example.com", Password: "password", Repeat: "password"}, ValidationErrors{"": "pick a better password"}},
+		{"rules before validator", signupInput{Password: "a"}, ValidationErrors{"email": "is required"}},
+	}
+	for _, test := range tests {
+		err := Validate(&test.input)
+		var errs ValidationErrors
+		if test.want == nil && err != nil || test.want != nil && (!errors.As(err, &errs) || !reflect.DeepEqual(errs, test.want)) {
+			t.Errorf("%s: Validate = %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	type unknownRule struct {
+		Name string `validate:"required,shiny"`
+	}
+	err := Validate(&unknownRule{Name: "a"})
+	var errs ValidationErrors
+	if err == nil || errors.As(err, &errs) {
+		t.Errorf("unknown rules should be a configuration error: %v", err)
+	}
+
+	RegisterValidationRule("even", func(value reflect.Value, _ string) error {
+		if value.Int()%2 != 0 {
+			return errors.New("must be even")
+		}
+		return nil
+	})
+	defer func() {
+		validationRules.Lock()
+		delete(validationRules.rules, "even")
+		validationRules.Unlock()
+	}()
+	type customRule struct {
+		N int `form:"n" validate:"even"`
+	}
+	if err := Validate(&customRule{N: 3}); err == nil || err.Error() != "n must be even" {
+		t.Errorf("custom rule: %v", err)
+	}
+}
+
+func TestCSSEscapeID(t *testing.T) {
+	tests := map[string]string{
+		"signup":    "signup",
+		"sign-up_2": "sign-up_2",
+		"2fa":       `\32 fa`,
+		"-2":        `-\32 `,
+		"-":         `\2d `,
+		"a.b:c":     `a\2e b\3a c`,
+		"a b":       `a\20 b`,
+	}
+	for id, want := range tests {
+		if got := cssEscapeID(id); got != want {
+			t.Errorf("cssEscapeID(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestReturnFormErrors(t *testing.T) {
+	newTestRouter(t, nil, map[string]string{
+		"api/signup.gohtml": `<form id="signup"><input name="email" value="{{.Values.Email}}">{{index .Errors "email"}}</form>`,
+	})
+	in := signupInput{Email: "ada<"}
+	errs := ValidationErrors{"email": "must be a valid email address"}
+	want := `<form id="signup"><input name="email" value="ada&lt;">must be a valid email address</form>`
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		retarget string
+		swap     string
+	}{
+		// a form targeting itself replaces itself
+		{"form target", http.Header{"Hx-Target": {"signup"}, "Hx-Trigger": {"signup"}}, "#signup", "outerHTML"},
+		{"button trigger", http.Header{"Hx-Target": {"signup"}, "Hx-Trigger": {"submit"}}, "#signup", "outerHTML"},
+		// other targets are left to htmx
+		{"other target", http.Header{"Hx-Target": {"result"}, "Hx-Trigger": {"signup"}}, "", ""},
+		{"no target", http.Header{"Hx-Trigger": {"signup"}}, "", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/signup", nil)
+		r.Header = test.header
+		r.Header.Set("HX-Request", "true")
+		err := ReturnFormErrors(w, r, []string{"signup.gohtml"}, "", in, errs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnprocessableEntity || w.Body.String() != want {
+			t.Errorf("%s: %d %s", test.name, w.Code, w.Body.String())
+		}
+		if w.Header().Get("HX-Retarget") != test.retarget || w.Header().Get("HX-Reswap") != test.swap {
+			t.Errorf("%s: headers = %v", test.name, w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	err := ReturnFormErrors(w, httptest.NewRequest(http.MethodPost, "/signup", nil), []string{"signup.gohtml"}, "", in, errs)
+	if err != nil || w.Code != http.StatusUnprocessableEntity || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || w.Header().Get("HX-Retarget") != "" {
+		t.Errorf("full page: %v %d %v", err, w.Code, w.Header())
+	}
+
+	// a failing template leaves the response untouched
+	w = httptest.NewRecorder()
+	err = ReturnFormErrors(w, httptest.NewRequest(http.MethodPost, "/signup", nil), []string{"missing.gohtml"}, "", in, errs)
+	if err == nil || w.Body.Len() != 0 || len(w.Header()) != 0 {
+		t.Errorf("missing template: %v %v", err, w.Header())
+	}
+}
@@ -0,0 +1,632 @@
+package gomx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomxapp/gomx/htmx"
+	"github.com/gomxapp/gomx/internal"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is appended to the client's key to compute the accept key
+// (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// WebSocketMaxMessage is the default limit on the size of messages read
+	// from a WebSocketConn. See WebSocketConn.SetReadLimit.
+	WebSocketMaxMessage int64 = 1 << 20
+	// WebSocketPingInterval is how often a WebSocketHandler pings the client.
+	// A connection that sends nothing, not even a pong, for two intervals is
+	// closed.
+	WebSocketPingInterval = 30 * time.Second
+	// WebSocketCheckOrigin decides whether a handshake is accepted. The
+	// default accepts requests without an Origin header and requests from
+	// the same host, which keeps other sites from opening connections with
+	// the user's cookies.
+	WebSocketCheckOrigin = sameOrigin
+)
+
+// WebSocketMessageType is the type of a data message.
+type WebSocketMessageType int
+
+const (
+	WebSocketText   WebSocketMessageType = 1
+	WebSocketBinary WebSocketMessageType = 2
+)
+
+// Frame opcodes (RFC 6455, section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes (RFC 6455, section 7.4.1).
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseUnsupportedData = 1003
+	WebSocketCloseNoStatus        = 1005
+	WebSocketCloseAbnormal        = 1006
+	WebSocketCloseInvalidPayload  = 1007
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseTooLarge        = 1009
+	WebSocketCloseInternalError   = 1011
+)
+
+// maxControlPayload is the largest payload of a control frame.
+const maxControlPayload = 125
+
+// WebSocketCloseError is returned by ReadMessage once the connection is
+// closed, by either side. Code is WebSocketCloseNoStatus if the closing frame
+// had no code, WebSocketCloseAbnormal if the connection was lost without one,
+// and WebSocketCloseGoingAway if the server stopped.
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// WebSocketConn is a server-side WebSocket connection. Messages can be written
+// concurrently with reading, but only one goroutine may read at a time.
+type WebSocketConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	r         *http.Request
+	ctx       context.Context
+	readLimit int64
+
+	mu        sync.Mutex
+	writer    *bufio.Writer
+	closeSent bool
+	closeErr  *WebSocketCloseError
+}
+
+// Request returns the request of the handshake.
+func (conn *WebSocketConn) Request() *http.Request {
+	return conn.r
+}
+
+// Context is canceled when the handler returns, the connection is closed, or
+// the server is closed or shut down.
+func (conn *WebSocketConn) Context() context.Context {
+	return conn.ctx
+}
+
+// SetReadLimit sets the maximum size of a message. A larger message closes the
+// connection with WebSocketCloseTooLarge.
+func (conn *WebSocketConn) SetReadLimit(limit int64) {
+	conn.readLimit = limit
+}
+
+// ReadMessage returns the next data message. Pings are answered while
+// reading. Once the connection is closed, it returns a *WebSocketCloseError.
+func (conn *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	var messageType WebSocketMessageType
+	var message []byte
+	for {
+		fin, opcode, payload, err := conn.readFrame()
+		if err != nil {
+			return 0, nil, conn.readFailed(err)
+		}
+		switch opcode {
+		case opPing:
+			err = conn.writeFrame(opPong, payload)
+			if err != nil {
+				return 0, nil, conn.readFailed(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, conn.closeReceived(payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, conn.fail(WebSocketCloseProtocolError, "expected a continuation frame")
+			}
+			messageType = WebSocketMessageType(opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, conn.fail(WebSocketCloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, conn.fail(WebSocketCloseProtocolError, "unknown opcode")
+		}
+		if int64(len(message))+int64(len(payload)) > conn.readLimit {
+			return 0, nil, conn.fail(WebSocketCloseTooLarge, "message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			if messageType == WebSocketText && !utf8.Valid(message) {
+				return 0, nil, conn.fail(WebSocketCloseInvalidPayload, "invalid UTF-8")
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// protocolError is a frame that breaks RFC 6455, which closes the connection
+// with its code.
+type protocolError struct {
+	code   int
+	reason string
+}
+
+func (e *protocolError) Error() string {
+	return e.reason
+}
+
+func (conn *WebSocketConn) readFrame() (bool, byte, []byte, error) {
+	err := conn.conn.SetReadDeadline(time.Now().Add(2 * WebSocketPingInterval))
+	if err != nil {
+		return false, 0, nil, err
+	}
+	var header [2]byte
+	_, err = io.ReadFull(conn.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &protocolError{WebSocketCloseProtocolError, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &protocolError{WebSocketCloseProtocolError, "client frames must be masked"}
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(conn.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(conn.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if opcode >= opClose && (!fin || length > maxControlPayload) {
+		return false, 0, nil, &protocolError{WebSocketCloseProtocolError, "invalid control frame"}
+	}
+	// checked before reading, so a large length does not allocate
+	if length > uint64(conn.readLimit) {
+		return false, 0, nil, &protocolError{WebSocketCloseTooLarge, "message too large"}
+	}
+	var mask [4]byte
+	_, err = io.ReadFull(conn.reader, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(conn.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// readFailed closes the connection after a read error and returns the error
+// ReadMessage reports.
+func (conn *WebSocketConn) readFailed(err error) error {
+	var protoErr *protocolError
+	if errors.As(err, &protoErr) {
+		return conn.fail(protoErr.code, protoErr.reason)
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closeErr != nil {
+		return conn.closeErr
+	}
+	_ = conn.conn.Close()
+	// nothing can be sent on a broken connection, not even a close frame
+	conn.closeSent = true
+	conn.closeErr = &WebSocketCloseError{Code: WebSocketCloseAbnormal, Reason: err.Error()}
+	return conn.closeErr
+}
+
+// fail closes the connection with code because the client broke the protocol
+// or a limit.
+func (conn *WebSocketConn) fail(code int, reason string) error {
+	_ = conn.Close(code, reason)
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+func (conn *WebSocketConn) closeReceived(payload []byte) error {
+	closeErr := &WebSocketCloseError{Code: WebSocketCloseNoStatus}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+	}
+	// echo the code, as the closing handshake asks
+	code := closeErr.Code
+	if code == WebSocketCloseNoStatus {
+		code = WebSocketCloseNormal
+	}
+	_ = conn.Close(code, "")
+	return closeErr
+}
+
+// WriteMessage sends a data message in a single frame.
+func (conn *WebSocketConn) WriteMessage(messageType WebSocketMessageType, data []byte) error {
+	if messageType != WebSocketText && messageType != WebSocketBinary {
+		return errors.New("invalid websocket message type")
+	}
+	return conn.writeFrame(byte(messageType), data)
+}
+
+// WriteText sends a text message.
+func (conn *WebSocketConn) WriteText(text string) error {
+	return conn.writeFrame(opText, []byte(text))
+}
+
+// WriteJSON sends v encoded as JSON in a text message.
+func (conn *WebSocketConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.writeFrame(opText, data)
+}
+
+// WriteFragments renders fragments into one text message. The htmx WebSocket
+// extension swaps the elements of a message into the page by their ids, like
+// out-of-band swaps; fragments with a Swap get it as their hx-swap-oob
+// attribute.
+func (conn *WebSocketConn) WriteFragments(fragments ...Fragment) error {
+	buf := internal.GetBuffer()
+	defer internal.PutBuffer(buf)
+	fragmentBuf := internal.GetBuffer()
+	defer internal.PutBuffer(fragmentBuf)
+	for i := range fragments {
+		if fragments[i].Swap == "" {
+			err := fragments[i].render(buf)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fragmentBuf.Reset()
+		err := fragments[i].render(fragmentBuf)
+		if err != nil {
+			return err
+		}
+		err = writeOOB(buf, fragmentBuf.Bytes(), fragments[i].Swap)
+		if err != nil {
+			return fmt.Errorf("fragment %d: %w", i, err)
+		}
+	}
+	return conn.writeFrame(opText, buf.Bytes())
+}
+
+// Ping sends a ping with data, which the client answers with a pong.
+func (conn *WebSocketConn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("ping payload too large")
+	}
+	return conn.writeFrame(opPing, data)
+}
+
+// Close sends a close frame with code and reason and closes the connection.
+// It does nothing if the connection is already closed.
+func (conn *WebSocketConn) Close(code int, reason string) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closeSent {
+		return nil
+	}
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	err := conn.writeFrameLocked(opClose, payload)
+	conn.closeSent = true
+	if conn.closeErr == nil {
+		conn.closeErr = &WebSocketCloseError{Code: code, Reason: reason}
+	}
+	closeErr := conn.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (conn *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closeSent {
+		return conn.closeErr
+	}
+	return conn.writeFrameLocked(opcode, payload)
+}
+
+func (conn *WebSocketConn) writeFrameLocked(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	err := conn.conn.SetWriteDeadline(time.Now().Add(WebSocketPingInterval))
+	if err != nil {
+		return err
+	}
+	_, err = conn.writer.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = conn.writer.Write(payload)
+	if err != nil {
+		return err
+	}
+	return conn.writer.Flush()
+}
+
+// WebSocketHandler serves WebSocket connections. It can be registered like any
+// other handler:
+//
+//	gomx.RegisterOnPath("/chat", http.MethodGet, gomx.WebSocketHandler(func(conn *gomx.WebSocketConn) error {
+//		for {
+//			_, data, err := conn.ReadMessage()
+//			if err != nil {
+//				return nil
+//			}
+//			msg, err := gomx.ParseHTMXMessage(data)
+//			if err != nil {
+//				return err
+//			}
+//			err = conn.WriteFragments(gomx.Fragment{Name: "chat-message", Data: msg.Values.Get("text")})
+//			if err != nil {
+//				return err
+//			}
+//		}
+//	}))
+//
+// Requests that are not WebSocket handshakes, or that WebSocketCheckOrigin
+// rejects, get an error response. The connection is closed when the function
+// returns, with WebSocketCloseInternalError if it returned an error, or with
+// WebSocketCloseGoingAway when the server is closed or shut down.
+type WebSocketHandler func(conn *WebSocketConn) error
+
+func (handler WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, status, reason := checkHandshake(r)
+	if status != 0 {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, reason, status)
+		return
+	}
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("Error upgrading %s to a websocket\n\t%v\n", r.URL.Path, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// hijacked connections do not get the server's deadlines
+	_ = netConn.SetDeadline(time.Time{})
+	ctx, cancel := stopContext(r)
+	defer cancel()
+	conn := &WebSocketConn{
+		conn:      netConn,
+		reader:    brw.Reader,
+		writer:    brw.Writer,
+		r:         r,
+		ctx:       ctx,
+		readLimit: WebSocketMaxMessage,
+	}
+	_, err = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		_ = netConn.Close()
+		return
+	}
+
+	// the server does not track hijacked connections, so they are closed here
+	// when it stops
+	stopClosing := context.AfterFunc(ctx, func() {
+		_ = conn.Close(WebSocketCloseGoingAway, "server stopping")
+	})
+	defer stopClosing()
+	go func() {
+		ticker := time.NewTicker(WebSocketPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if conn.Ping(nil) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	err = handler(conn)
+	var closeErr *WebSocketCloseError
+	if err != nil && !errors.As(err, &closeErr) {
+		log.Printf("Error in websocket %s\n\t%v\n", r.URL.Path, err)
+		_ = conn.Close(WebSocketCloseInternalError, "")
+		return
+	}
+	_ = conn.Close(WebSocketCloseNormal, "")
+}
+
+// checkHandshake validates an opening handshake (RFC 6455, section 4.2.1) and
+// returns the client's key, or an error status and reason.
+func checkHandshake(r *http.Request) (string, int, string) {
+	if r.Method != http.MethodGet {
+		return "", http.StatusMethodNotAllowed, "websocket handshakes must use GET"
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return "", http.StatusBadRequest, "not a websocket handshake"
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return "", http.StatusUpgradeRequired, "unsupported websocket version"
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return "", http.StatusBadRequest, "invalid Sec-WebSocket-Key"
+	}
+	if !WebSocketCheckOrigin(r) {
+		return "", http.StatusForbidden, "origin not allowed"
+	}
+	return key, 0, ""
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// HTMXMessage is a message sent by the htmx WebSocket extension with ws-send:
+// the values of the form or element that sent it, and the htmx request
+// headers, like HX-Trigger.
+type HTMXMessage struct {
+	Values  url.Values
+	Headers http.Header
+}
+
+// ParseHTMXMessage parses the JSON object sent by ws-send. Values are strings,
+// or arrays for repeated form fields; the htmx headers are in HEADERS.
+func ParseHTMXMessage(data []byte) (*HTMXMessage, error) {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+	msg := &HTMXMessage{
+		Values:  make(url.Values),
+		Headers: make(http.Header),
+	}
+	for key, value := range raw {
+		if key == "HEADERS" {
+			var headers map[string]any
+			err = json.Unmarshal(value, &headers)
+			if err != nil {
+				return nil, fmt.Errorf("invalid HEADERS: %w", err)
+			}
+			for name, header := range headers {
+				if header != nil {
+					msg.Headers.Set(name, fmt.Sprint(header))
+				}
+			}
+			continue
+		}
+		values, err := jsonFormValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		msg.Values[key] = values
+	}
+	return msg, nil
+}
+
+// jsonFormValues converts a value of a ws-send message back to form values.
+func jsonFormValues(value json.RawMessage) ([]string, error) {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	// keeps large numbers from being formatted with exponents
+	decoder.UseNumber()
+	err := decoder.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[string]any:
+		return nil, errors.New("objects are not form values")
+	}
+	return []string{fmt.Sprint(v)}, nil
+}
+
+// Bind sets the fields of the struct v points to from the message values,
+// using form tags like Bind does for requests.
+func (msg *HTMXMessage) Bind(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("bind target must be a non-nil pointer to a struct")
+	}
+	return bindStruct(rv.Elem(), "form", func(key string) []string {
+		return msg.Values[key]
+	})
+}
+
+// TriggerID returns the id of the element that sent the message.
+func (msg *HTMXMessage) TriggerID() string {
+	return msg.Headers.Get(htmx.HeaderTrigger)
+}
+
+// TriggerName returns the name of the element that sent the message.
+func (msg *HTMXMessage) TriggerName() string {
+	return msg.Headers.Get(htmx.HeaderTriggerName)
+}
@@ -0,0 +1,265 @@
+package gomx
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal RFC 6455 client for testing WebSocketHandler.
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string, header http.Header) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	err = req.Write(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode == http.StatusSwitchingProtocols {
+		want := websocketAccept(req.Header.Get("Sec-WebSocket-Key"))
+		if got := res.Header.Get("Sec-WebSocket-Accept"); got != want {
+			t.Fatalf("Sec-WebSocket-Accept = %s, want %s", got, want)
+		}
+	}
+	return &wsClient{conn: conn, reader: reader}, res
+}
+
+func (c *wsClient) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *wsClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func (c *wsClient) expectClose(t *testing.T, code int) {
+	t.Helper()
+	opcode, payload := c.readFrame(t)
+	if opcode != opClose || len(payload) < 2 {
+		t.Fatalf("expected a close frame, got opcode %d", opcode)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		t.Fatalf("close code = %d, want %d", got, code)
+	}
+}
+
+// echoHandler echoes text messages, and answers htmx messages with their
+// "text" value.
+var echoHandler = WebSocketHandler(func(conn *WebSocketConn) error {
+	conn.SetReadLimit(1024)
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if messageType == WebSocketBinary {
+			msg, err := ParseHTMXMessage(data)
+			if err != nil {
+				return err
+			}
+			var in struct {
+				Text string   `form:"text"`
+				Tags []string `form:"tag"`
+			}
+			err = msg.Bind(&in)
+			if err != nil {
+				return err
+			}
+			err = conn.WriteText(msg.TriggerID() + ":" + in.Text + ":" + strings.Join(in.Tags, ","))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = conn.WriteMessage(messageType, data)
+		if err != nil {
+			return err
+		}
+	}
+})
+
+func TestWebSocket(t *testing.T) {
+	ts := httptest.NewServer(echoHandler)
+	defer ts.Close()
+	client, res := dialWebSocket(t, ts.URL, nil)
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", res.StatusCode)
+	}
+
+	client.writeFrame(t, true, opText, []byte("hello"))
+	if opcode, payload := client.readFrame(t); opcode != opText || string(payload) != "hello" {
+		t.Errorf("echo = %d %q", opcode, payload)
+	}
+
+	// a fragmented message with a ping in between
+	client.writeFrame(t, false, opText, []byte("frag"))
+	client.writeFrame(t, true, opPing, []byte("p"))
+	client.writeFrame(t, true, opContinuation, []byte("mented"))
+	if opcode, payload := client.readFrame(t); opcode != opPong || string(payload) != "p" {
+		t.Errorf("pong = %d %q", opcode, payload)
+	}
+	if _, payload := client.readFrame(t); string(payload) != "fragmented" {
+		t.Errorf("echo = %q", payload)
+	}
+
+	long := strings.Repeat("x", 300)
+	client.writeFrame(t, true, opText, []byte(long))
+	if _, payload := client.readFrame(t); string(payload) != long {
+		t.Errorf("echo of %d bytes = %d bytes", len(long), len(payload))
+	}
+
+	htmxMessage := `{"text": "hi", "tag": ["a", "b"], "HEADERS": {"HX-Request": "true", "HX-Trigger": "chat-form", "HX-Target": null}}`
+	client.writeFrame(t, true, opBinary, []byte(htmxMessage))
+	if _, payload := client.readFrame(t); string(payload) != "chat-form:hi:a,b" {
+		t.Errorf("htmx reply = %q", payload)
+	}
+
+	client.writeFrame(t, true, opClose, binary.BigEndian.AppendUint16(nil, WebSocketCloseNormal))
+	client.expectClose(t, WebSocketCloseNormal)
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	ts := httptest.NewServer(echoHandler)
+	defer ts.Close()
+
+	client, _ := dialWebSocket(t, ts.URL, nil)
+	client.writeFrame(t, true, opText, make([]byte, 2000))
+	client.expectClose(t, WebSocketCloseTooLarge)
+
+	client, _ = dialWebSocket(t, ts.URL, nil)
+	client.writeFrame(t, false, opText, make([]byte, 1000))
+	client.writeFrame(t, true, opContinuation, make([]byte, 1000))
+	client.expectClose(t, WebSocketCloseTooLarge)
+
+	client, _ = dialWebSocket(t, ts.URL, nil)
+	client.writeFrame(t, true, opText, []byte{0xff, 0xfe})
+	client.expectClose(t, WebSocketCloseInvalidPayload)
+
+	client, _ = dialWebSocket(t, ts.URL, nil)
+	client.writeFrame(t, false, opPing, nil)
+	client.expectClose(t, WebSocketCloseProtocolError)
+
+	_, res := dialWebSocket(t, ts.URL, http.Header{"Origin": {"https://evil.example"}})
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin handshake status = %d", res.StatusCode)
+	}
+	_, res = dialWebSocket(t, ts.URL, http.Header{"Sec-Websocket-Version": {"8"}})
+	if res.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("version 8 handshake status = %d", res.StatusCode)
+	}
+}
+
+func TestWebSocketServerClose(t *testing.T) {
+	done := make(chan error, 1)
+	ts := httptest.NewUnstartedServer(nil)
+	server := NewServer(ts.Config, nil)
+	ts.Config.Handler = WebSocketHandler(func(conn *WebSocketConn) error {
+		_, _, err := conn.ReadMessage()
+		done <- err
+		return err
+	})
+	ts.Start()
+	defer ts.Close()
+
+	client, _ := dialWebSocket(t, ts.URL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectClose(t, WebSocketCloseGoingAway)
+	var closeErr *WebSocketCloseError
+	if err := <-done; !errors.As(err, &closeErr) || closeErr.Code != WebSocketCloseGoingAway {
+		t.Errorf("ReadMessage error = %v", err)
+	}
+}